@@ -0,0 +1,196 @@
+package main
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+func init() {
+	config.Register(config.Key{Name: "aliases.*", Usage: "Command line an alias runs, followed by the arguments it is given, e.g. kssh -e prod -a Frontend"})
+	config.Register(config.Key{Name: "macros.*", Usage: "Commands a macro runs, chained with ;, && or ||, in which $1 to $9, $@ and $# refer to its arguments"})
+}
+
+//expandingKey is the context key of the aliases and macros being expanded, to stop one that refers to itself
+type expandingKey struct{}
+
+var (
+	shadowMu sync.Mutex
+	//shadowWarned holds the aliases and macros already reported as shadowed by a command
+	shadowWarned = map[string]bool{}
+)
+
+//isCommand reports whether name is a built-in or registered command, which always wins over an alias
+func isCommand(name string) bool {
+	_, builtin := findBuiltin(name)
+	_, registered := CmdRegistry.Lookup(name)
+	return builtin || registered || name == "__complete"
+}
+
+//aliasNames returns the names of the aliases and macros that don't clash with a command, sorted. Those that do
+//are reported once, since they never run.
+func aliasNames(ctx context.Context) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, prefix := range []string{"aliases", "macros"} {
+		for _, name := range config.Children(prefix) {
+			if isCommand(name) {
+				shadowMu.Lock()
+				if !shadowWarned[prefix+"."+name] {
+					shadowWarned[prefix+"."+name] = true
+					logging.Warn(ctx, fmt.Sprintf("Ignoring %s.%s, %s is a command. Rename it to use it.", prefix, name, name))
+				}
+				shadowMu.Unlock()
+				continue
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//registerAliases offers the aliases and macros to completion and suggestions next to the built-in commands.
+//It is called again before every interactive line so that aliases set in the session show up.
+func registerAliases(ctx context.Context) {
+	names := make([]string, 0, len(builtins))
+	for _, b := range builtins {
+		names = append(names, b.name)
+	}
+	CmdRegistry.Builtins = append(names, aliasNames(ctx)...)
+}
+
+//lookupAlias returns whether name is an "alias" or a "macro" and its definition. An alias takes precedence over
+//a macro of the same name.
+func lookupAlias(ctx context.Context, name string) (string, string, bool) {
+	if isCommand(name) {
+		return "", "", false
+	}
+	if value := config.Get("aliases." + name); value != "" {
+		return "alias", value, true
+	}
+	if value := config.Get("macros." + name); value != "" {
+		return "macro", value, true
+	}
+	return "", "", false
+}
+
+//processAlias runs the alias or macro called cmd, if there is one. An alias runs its command line followed by
+//args, a macro runs its commands with its positional references replaced by args.
+func processAlias(ctx context.Context, cmd string, args []string) (int, bool) {
+	kind, value, ok := lookupAlias(ctx, cmd)
+	if !ok {
+		return 0, false
+	}
+	outer, _ := ctx.Value(expandingKey{}).(map[string]bool)
+	if outer[cmd] {
+		return fail(ctx, CmdRegistry.Usagef("%s %s refers to itself", kind, cmd)), true
+	}
+	expanding := map[string]bool{cmd: true}
+	for name := range outer {
+		expanding[name] = true
+	}
+	ctx = context.WithValue(ctx, expandingKey{}, expanding)
+
+	if kind == "macro" {
+		cmds, err := shell.Parse(shell.Positional(value, args))
+		if err != nil {
+			return fail(ctx, CmdRegistry.Usagef("macro %s: %v", cmd, err)), true
+		}
+		status, _ := runCommands(ctx, cmds, lookupVar)
+		return status, true
+	}
+	words, err := aliasWords(cmd, value)
+	if err != nil {
+		return fail(ctx, err), true
+	}
+	return processCmd(ctx, words[0], append(words[1:], args...)), true
+}
+
+//aliasWords parses the command line of an alias, which must be a single command
+func aliasWords(name string, value string) ([]string, error) {
+	cmds, err := shell.Parse(value)
+	if err != nil {
+		return nil, CmdRegistry.Usagef("alias %s: %v", name, err)
+	}
+	if len(cmds) != 1 || cmds[0].Redirect != shell.RedirectNone || cmds[0].Background {
+		return nil, CmdRegistry.Usagef("alias %s must be a single command, define a macro to run several", name)
+	}
+	c, err := cmds[0].Expand(lookupVar)
+	if err != nil {
+		return nil, CmdRegistry.Usagef("alias %s: %v", name, err)
+	}
+	if len(c.Args) == 0 {
+		return nil, CmdRegistry.Usagef("alias %s expands to nothing", name)
+	}
+	return c.Args, nil
+}
+
+//expandAlias replaces the alias at the start of words with its command line for completion, so that the flags
+//of the aliased command are offered after the alias
+func expandAlias(words []string) []string {
+	if len(words) < 2 {
+		return words
+	}
+	kind, value, ok := lookupAlias(context.Background(), words[0])
+	if !ok || kind != "alias" {
+		return words
+	}
+	expanded, err := aliasWords(words[0], value)
+	if err != nil {
+		return words
+	}
+	return append(expanded, words[1:]...)
+}
+
+//printAliases writes the aliases and macros with their definitions for the help overview
+func printAliases(ctx context.Context, w io.Writer) {
+	names := aliasNames(ctx)
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Aliases and Macros")
+	for _, name := range names {
+		kind, value, _ := lookupAlias(ctx, name)
+		fmt.Fprintf(w, "  %-10s %s\n", name, describeAlias(kind, value))
+	}
+}
+
+//describeAlias returns the usage shown in help for an alias or a macro
+func describeAlias(kind string, value string) string {
+	if kind == "macro" {
+		return "Macro running: " + value
+	}
+	return "Alias of: " + value
+}
+
+//printAliasHelp writes the help of an alias or a macro, which for an alias is followed by the help of the
+//command it runs
+func printAliasHelp(ctx context.Context, name string) bool {
+	kind, value, ok := lookupAlias(ctx, name)
+	if !ok {
+		return false
+	}
+	w := output.Stdout(ctx)
+	fmt.Fprintf(w, "Command: %s\nUsage: %s\n", name, describeAlias(kind, value))
+	if kind != "alias" {
+		return true
+	}
+	if words, err := aliasWords(name, value); err == nil {
+		if path, _, ok := CmdRegistry.Resolve(words[0], words[1:]); ok {
+			fmt.Fprintln(w, "")
+			CmdRegistry.PrintHelp(w, path)
+		}
+	}
+	return true
+}
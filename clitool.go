@@ -2,15 +2,28 @@ package main
 
 import (
 	_ "clitool/cmd/assume"
+	_ "clitool/cmd/completion"
+	_ "clitool/cmd/config"
+	_ "clitool/cmd/contexts"
+	_ "clitool/cmd/docs"
 	_ "clitool/cmd/elastic"
 	_ "clitool/cmd/kssh"
+	_ "clitool/cmd/schema"
 	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/plugin"
+	"clitool/utils/shell"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 )
@@ -20,31 +33,144 @@ var mainFlagSet flag.FlagSet
 var cmd string
 var args []string
 
+//mainAliases maps the other names of the main flags to the flag help lists them with
+var mainAliases = map[string]string{"verbose": "v", "quiet": "q"}
+
+//builtin is a command handled by the dispatcher itself, along with its usage for help
+type builtin struct {
+	name  string
+	usage string
+}
+
+//builtins are the commands handled by the dispatcher itself
+var builtins = []builtin{
+	{"help", "Prints the help of every command, or of the command path given as arguments, e.g. help assume list."},
+	{"exit", "Exits interactive mode."},
+	{"run", "Runs the commands of a script file line by line, \"-\" reads them from stdin. Usage: run [-e] [-x] <file>"},
+	{"set", "Sets script options. -e stops at the first failing command and -x prints each command before it runs. Use + to unset them. NAME=value sets a session variable."},
+	{"history", "Lists the interactive history, or only the lines containing the given text. Replay line n with !n, the last line with !!. Usage: history [text]"},
+	{"unset", "Removes session variables. Usage: unset NAME..."},
+	{"vars", "Lists the session variables. $_ and $last.<field> refer to the result of the last command."},
+	{"jobs", "Lists the background jobs started by ending a line with &."},
+	{"fg", "Prints the output of a background job and waits for it, Ctrl-C cancels it. Usage: fg [n]"},
+	{"kill", "Cancels a background job. Usage: kill [n]"},
+	{"wait", "Waits for a background job, or for all of them, to finish. Usage: wait [n]"},
+}
+
 func init() {
+	for _, b := range builtins {
+		CmdRegistry.Builtins = append(CmdRegistry.Builtins, b.name)
+	}
 	mainFlagSet = *flag.NewFlagSet("main", flag.ContinueOnError)
 	mainFlagSet.BoolVar(&interactive, "i", false, "Specifies whether CanopyCLI should be run in interactive mode or not.")
+	mainFlagSet.Var(configFlag{}, "c", "Overrides a setting for this run, in key=value form. May be repeated.")
+	mainFlagSet.Var(outputFlag{}, "o", "Output format of command results: "+strings.Join(output.Formats, ", ")+". Same as -c output=<format>.")
+	for _, name := range []string{"v", "verbose"} {
+		mainFlagSet.Var(levelFlag{name, "debug"}, name, "Also log debug messages, such as the fields of each message and stack traces. Same as -c log.level=debug.")
+	}
+	for _, name := range []string{"q", "quiet"} {
+		mainFlagSet.Var(levelFlag{name, "error"}, name, "Only log errors. Same as -c log.level=error.")
+	}
+	mainFlagSet.Var(logFileFlag{}, "log-file", "Appends the log messages to the file as JSON lines. Same as -c log.file=<path>.")
+}
+
+//levelFlag sets the log.level setting to level when the boolean flag name is given
+type levelFlag struct {
+	name  string
+	level string
+}
+
+func (levelFlag) String() string   { return "" }
+func (levelFlag) IsBoolFlag() bool { return true }
+
+func (l levelFlag) Set(s string) error {
+	if on, err := strconv.ParseBool(s); err != nil || !on {
+		return err
+	}
+	return config.SetFlagFrom("-"+l.name, "log.level", l.level)
+}
+
+//logFileFlag sets the log.file setting from --log-file
+type logFileFlag struct{}
+
+func (logFileFlag) String() string { return "" }
+
+func (logFileFlag) Set(s string) error {
+	return config.SetFlagFrom("-log-file", "log.file", s)
+}
+
+//outputFlag sets the output setting from -o after checking the format
+type outputFlag struct{}
+
+func (outputFlag) String() string { return "" }
+
+func (outputFlag) Set(s string) error {
+	if _, err := output.ParseFormat(s); err != nil {
+		return err
+	}
+	return config.SetFlagFrom("-o", "output", s)
+}
+
+//configFlag sets the flag layer of the config from -c key=value
+type configFlag struct{}
+
+func (configFlag) String() string { return "" }
+
+func (configFlag) Set(s string) error {
+	eq := strings.Index(s, "=")
+	if eq <= 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	return config.SetFlag(s[:eq], s[eq+1:])
 }
 
 func main() {
 
-	mainFlagSet.Parse(os.Args[1:])
+	if err := CmdRegistry.ParseFlags(&mainFlagSet, os.Args[1:]); err != nil {
+		os.Exit(CmdRegistry.ExitCode(err))
+	}
+	ctx := context.Background()
+	if err := logging.Configure(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error setting up logging:", err)
+		os.Exit(CmdRegistry.ExitUsage)
+	}
+	if errs := config.Errors(); len(errs) > 0 {
+		logging.Warn(ctx, fmt.Sprintf("ignoring %d invalid setting(s), run \"config validate\" to see them.", len(errs)))
+		for _, err := range errs {
+			logging.Debug(ctx, "Invalid setting", "error", err)
+		}
+	}
+	plugin.Register()
+	registerAliases(ctx)
 
 	if interactive {
 
 		fmt.Println("--- INTERACTIVE MODE ---") //TODO(Print something more awesome and lulz worthy)
 
+		history, err := historyFile()
+		if err != nil {
+			logging.Warn(ctx, "History won't be saved: "+err.Error())
+		}
 		rl, err := readline.NewEx(&readline.Config{
-			Prompt:      "\033[34mclitool>\033[0m ",
-			HistoryFile: "/tmp/readline.tmp",
-			EOFPrompt:   "exit",
+			Prompt:                 prompt(),
+			HistoryFile:            history,
+			HistoryLimit:           historySize(),
+			DisableAutoSaveHistory: true, //Lines are saved once expanded and redacted
+			EOFPrompt:              "exit",
+			AutoComplete:           replCompleter{},
 		})
 
 		if err != nil {
-			panic(err)
+			exit(fail(ctx, err))
 		}
 		defer rl.Close()
+		jobsCtx = ctx
+		notify = func(msg string) { fmt.Fprintln(rl.Stderr(), msg) } //Printed above the prompt
+		go refreshPrompt(rl)
 
 		for {
+			rl.SetPrompt(prompt()) //The active context or the assumed role may have changed with the last command
+			registerAliases(ctx)
 			line, err := rl.Readline()
 			if err == readline.ErrInterrupt {
 				if len(line) == 0 {
@@ -55,58 +181,306 @@ func main() {
 			} else if err == io.EOF {
 				break
 			}
-			lineSplit := strings.Fields(line)
-			cmd = lineSplit[0]   //get command from read line
-			args = lineSplit[1:] //get command arguments
-			processCmd(cmd, args)
+			line, expanded, err := expandHistory(line)
+			if err != nil {
+				fail(ctx, err)
+				continue
+			}
+			if expanded {
+				fmt.Fprintln(os.Stderr, line) //Shows what is being replayed
+			}
+			if strings.TrimSpace(line) != "" {
+				rl.SaveHistory(redact(line))
+			}
+			lineCtx, stop := interruptible(ctx)
+			processLine(lineCtx, line)
+			stop()
 		}
+		cancelJobs()
 
 	} else {
-		cmd = os.Args[1]
-		args = os.Args[2:]
-		processCmd(cmd, args)
+		ctx, _ := interruptible(ctx) //Listens until the process exits
+		jobsCtx = ctx
+		if mainFlagSet.NArg() == 0 && !readline.IsTerminal(int(os.Stdin.Fd())) {
+			exit(runScript(ctx, os.Stdin, "stdin")) //Commands are being piped in
+		}
+		if mainFlagSet.NArg() == 0 {
+			logging.Error(ctx, "No command specified! Run help to see all commands and flags.")
+			exit(CmdRegistry.ExitUsage)
+		}
+		cmd = mainFlagSet.Arg(0)
+		args = mainFlagSet.Args()[1:]
+		exit(processCmd(ctx, cmd, args))
+	}
+
+}
+
+//exit closes the log file and exits with code
+func exit(code int) {
+	logging.Close()
+	os.Exit(code)
+}
+
+//processLine parses a line of interactive input and runs each command in it, honoring ;, && and ||.
+//It returns the exit status of the last command that ran.
+func processLine(ctx context.Context, line string) int {
+	cmds, err := shell.Parse(line)
+	if err != nil {
+		logging.Error(ctx, "Error parsing command: "+err.Error())
+		setExitStatus(CmdRegistry.ExitUsage)
+		return CmdRegistry.ExitUsage
+	}
+	status, _ := runCommands(ctx, cmds, lookupVar)
+	return status
+}
+
+//runCommands runs a parsed line, echoing each command first when xtrace is set. Lists ended by & are started
+//as background jobs. The words of each command are expanded with lookup right before it runs. It returns the
+//status of the last command that ran and the status of the first one that failed, unless it was guarded by ||.
+//With errexit set, the line stops at that failure.
+func runCommands(ctx context.Context, cmds []shell.Command, lookup shell.LookupFunc) (int, int) {
+	//$? is the status left by the prompt or the script. The commands of background jobs and hooks don't change it,
+	//nor follow the script options, which the prompt may change while they run.
+	session := !output.FromContext(ctx).Background && ctx.Value(hookingKey{}) == nil
+	stopOnError, trace := session && errexit, session && xtrace
+	status, failed := exitStatus(), CmdRegistry.ExitOK
+	setStatus := func(code int) int {
+		status = code
+		if session {
+			setExitStatus(code)
+		}
+		return code
+	}
+
+	for _, list := range shell.Lists(cmds) {
+		if stopOnError && failed != CmdRegistry.ExitOK {
+			break
+		}
+		if list[0].Background {
+			j := startJob(list)
+			fmt.Fprintf(os.Stderr, "[%d] %s\n", j.id, j.line)
+			setStatus(CmdRegistry.ExitOK)
+			continue
+		}
+		setStatus(shell.Run(list, status, func(c shell.Command) int {
+			if ctx.Err() != nil { //The rest of the line doesn't run once a command was interrupted
+				return CmdRegistry.ExitCancelled
+			}
+			if stopOnError && failed != CmdRegistry.ExitOK {
+				return failed
+			}
+			code := setStatus(runExpanded(ctx, c, status, lookup, trace))
+			if code != CmdRegistry.ExitOK && !c.Guarded && failed == CmdRegistry.ExitOK {
+				failed = code
+			}
+			return code
+		}))
 	}
+	return status, failed
+}
 
+//runExpanded expands the words of a command with lookup, $? being status, the status of the command before it,
+//and runs the command with its redirection, printing it first if trace is set. Expanding each command right before it runs lets it refer to the
+//variables set, the result emitted and the status left by the commands before it on the same line, e.g.
+//"kssh list && set IP=$last.private_ip".
+func runExpanded(ctx context.Context, c shell.Command, status int, lookup shell.LookupFunc, trace bool) int {
+	c, err := c.Expand(func(name string) (string, bool) {
+		if name == "?" {
+			return strconv.Itoa(status), true
+		}
+		return lookup(name)
+	})
+	if err != nil {
+		return fail(ctx, CmdRegistry.Usagef("%v", err))
+	}
+	if len(c.Args) == 0 {
+		return CmdRegistry.ExitOK //Every word expanded to nothing
+	}
+	if trace {
+		fmt.Fprintln(os.Stderr, "+", c)
+	}
+	return processRedirected(ctx, c)
 }
 
-func processCmd(cmd string, args []string) {
-	//Watches for the recover function to bubble errors up to the user and print a stack trace.
+//processCmd runs a single command with the arguments parsed by the caller and returns the exit code for it
+func processCmd(ctx context.Context, cmd string, args []string) (code int) {
+	//Watches for the recover function to bubble errors up to the user, with a stack trace at debug level.
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Println("Error running command:", r)
-			fmt.Printf("%s\n", debug.Stack())
+			logging.Error(ctx, fmt.Sprint("Error running command: ", r))
+			logging.Debug(ctx, "Stack trace", "stack", string(debug.Stack()))
+			code = CmdRegistry.ExitFailure
 		}
 	}()
 
+	if output.FromContext(ctx).Background && (cmd == "exit" || cmd == "fg" || cmd == "wait") {
+		return fail(ctx, CmdRegistry.Usagef("%s can't run in a background job", cmd))
+	}
+	if _, ok := findBuiltin(cmd); ok && len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		return processHelp(ctx, []string{cmd})
+	}
 	switch cmd {
 	case "help":
-		processHelp()
+		return processHelp(ctx, args)
 	case "exit":
-		processExit()
-	default:
-		for _, c := range CmdRegistry.Cmds {
-			if cmd == c.Name {
-				c.RunCmd()
-				return
+		return processExit(ctx)
+	case "run":
+		return processRun(ctx, args)
+	case "set":
+		return processSet(ctx, args)
+	case "history":
+		return processHistory(ctx, args)
+	case "unset":
+		return processUnset(ctx, args)
+	case "vars":
+		return processVars(ctx, args)
+	case "__complete":
+		return processComplete(ctx, args)
+	case "jobs":
+		return processJobs(ctx, args)
+	case "fg":
+		return processFg(ctx, args)
+	case "kill":
+		return processKill(ctx, args)
+	case "wait":
+		return processWait(ctx, args)
+	}
+
+	if code, ok := processAlias(ctx, cmd, args); ok {
+		return code
+	}
+
+	path, args, ok := CmdRegistry.Resolve(cmd, args)
+	if !ok {
+		return notFound(ctx, cmd)
+	}
+	ctx = logging.With(ctx, "command", path.Name())
+
+	format, err := output.Configured()
+	if err != nil {
+		return fail(ctx, CmdRegistry.Usagef("%v", err))
+	}
+	streams := output.FromContext(ctx)
+	streams.Format = format
+	recordResults(ctx, &streams)
+	ctx = output.WithStreams(ctx, streams)
+
+	logging.Debug(ctx, "Running command", "args", path.Redact(args))
+	start := time.Now()
+	err = CmdRegistry.Invoke(ctx, path, args)
+	code = CmdRegistry.ExitCode(err)
+	if code != CmdRegistry.ExitOK {
+		fail(ctx, err)
+	}
+	logging.Debug(ctx, "Command finished", "status", code, "duration", time.Since(start).String())
+	return code
+}
+
+//processHelp prints the help of the command path given in args, e.g. "help assume list", or of a built-in command.
+//Without args it lists every command, grouped by category.
+func processHelp(ctx context.Context, args []string) int {
+	w := output.Stdout(ctx)
+	if len(args) > 0 {
+		if b, ok := findBuiltin(args[0]); ok && len(args) == 1 {
+			fmt.Fprintf(w, "Command: %s\nUsage: %s\n", b.name, b.usage)
+			return CmdRegistry.ExitOK
+		}
+		if len(args) == 1 && printAliasHelp(ctx, args[0]) {
+			return CmdRegistry.ExitOK
+		}
+		path, rest, ok := CmdRegistry.Resolve(args[0], args[1:])
+		if !ok {
+			return notFound(ctx, args[0])
+		}
+		if len(rest) > 0 {
+			logging.Error(ctx, fmt.Sprintf("%s has no subcommand %q.%s", path.Name(), rest[0], CmdRegistry.DidYouMean(rest[0], CmdRegistry.Names(path.Leaf().Subcmds), "")))
+			return CmdRegistry.ExitNotFound
+		}
+		CmdRegistry.PrintHelp(w, path)
+		return CmdRegistry.ExitOK
+	}
+
+	fmt.Fprintln(w, "Usage: clitool [flags] <command> [subcommand] [flags] [args], or clitool -i for interactive mode")
+	fmt.Fprintln(w, "")
+	CmdRegistry.PrintCommands(w)
+	fmt.Fprintln(w, "Built-in Commands")
+	for _, b := range builtins {
+		fmt.Fprintf(w, "  %-10s %s\n", b.name, b.usage)
+	}
+	printAliases(ctx, w)
+	fmt.Fprintln(w, "Flags")
+	printMainFlags(w)
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Run \"help <command>\" or \"<command> -help\" to see the subcommands, flags and examples of a command.")
+	return CmdRegistry.ExitOK
+}
+
+//printMainFlags writes the help of the flags given before the command, listing all names of a flag in a single
+//entry like the help of a command
+func printMainFlags(w io.Writer) {
+	mainFlagSet.VisitAll(func(f *flag.Flag) {
+		if _, ok := mainAliases[f.Name]; ok {
+			return
+		}
+		names := []string{"-" + f.Name}
+		for alias, name := range mainAliases {
+			if name == f.Name {
+				names = append(names, "-"+alias)
 			}
 		}
-		fmt.Println("Command not found! Run help to see all commands and flags.")
+		label := strings.Join(names, ", ")
+		if typ, _ := flag.UnquoteUsage(f); typ != "" {
+			label += " " + typ
+		}
+		fmt.Fprintln(w, "  "+label)
+		fmt.Fprintln(w, "    \t"+f.Usage)
+	})
+}
+
+//findBuiltin returns the built-in command with the given name
+func findBuiltin(name string) (builtin, bool) {
+	for _, b := range builtins {
+		if b.name == name {
+			return b, true
+		}
 	}
+	return builtin{}, false
 }
 
-func processHelp() {
-	for _, fs := range CmdRegistry.FlagSets {
-		fmt.Println("Command: " + fs.Name())
-		fmt.Print("Usage: ")
-		fs.Usage()
-		fmt.Print("\nFlags and Arguments\n")
-		fs.PrintDefaults()
+//notFound reports an unknown command, suggesting the commands closest to it
+func notFound(ctx context.Context, name string) int {
+	names := append(CmdRegistry.Names(CmdRegistry.Cmds), CmdRegistry.Builtins...)
+	logging.Error(ctx, "Command not found!"+CmdRegistry.DidYouMean(name, names, "")+" Run help to see all commands and flags.", "command", name)
+	return CmdRegistry.ExitNotFound
+}
+
+//processComplete prints the completion candidates for the words of a command line, one per line. It is called
+//by the shell completion scripts to look up dynamic values.
+func processComplete(ctx context.Context, words []string) int {
+	for _, candidate := range CmdRegistry.Complete(ctx, expandAlias(words)) {
+		fmt.Fprintln(output.Stdout(ctx), candidate)
 	}
-	fmt.Println("")
-	fmt.Println("Command: ksftp\nUsage: Same usage as kssh but executes MSFTP instead of MSSH")
+	return CmdRegistry.ExitOK
+}
+
+//fail reports the error of a command with a message for its kind and returns its exit code
+func fail(ctx context.Context, err error) int {
+	logging.Error(ctx, CmdRegistry.Describe(err))
+	return CmdRegistry.ExitCode(err)
 }
 
-func processExit() {
+//exitWarned is set once the user was told that exiting cancels the running jobs
+var exitWarned bool
+
+//processExit exits the CLI. With jobs still running it only warns the first time, exiting cancels them.
+func processExit(ctx context.Context) int {
+	if n := runningJobs(); n > 0 && !exitWarned {
+		exitWarned = true
+		logging.Warn(ctx, fmt.Sprintf("%d job(s) still running, exit again to cancel them.", n))
+		return CmdRegistry.ExitFailure
+	}
+	cancelJobs()
 	fmt.Println("Goodbye!")
-	os.Exit(0)
+	exit(CmdRegistry.ExitOK)
+	return CmdRegistry.ExitOK
 }
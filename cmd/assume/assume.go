@@ -6,111 +6,173 @@ package assume
 import (
 	"clitool/utils"
 	"clitool/utils/CmdRegistry"
-	"encoding/json"
-	"flag"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/bigkevmcd/go-configparser"
 )
 
-var AssumeFlagSet flag.FlagSet
-var role string
-var profile string
-var secretAccessKey string
-var unassume bool
-var roleName string
+//assumeCmd holds the flags of a single assume invocation
+type assumeCmd struct {
+	role      string
+	profile   string
+	unassume  bool
+	roleName  string
+	credsFile *os.File
+}
+
 var homeDir, _ = os.UserHomeDir()
-var workingDir, _ = os.Getwd()
 
 //Flag constants
 const (
 	moduleUsage                 = "Assumes an AWS role and updates the users credentials file with the session token for that role. Use help to see what flags to use."
 	defaultRole                 = ""
 	roleUsage                   = "Specify which ARN role to assume"
-	defaultProfile              = ""
-	profileUsage                = "Specifies which profile in your ~/.aws/credentials file to use when requesting the role. Also used to retrieve the role ARN from the JSON config if thee role or roleName flag is unspecified."
-	roleNameUsage               = "Specifies which role name to use from the hardcoded RoleArns in the CLI. Use \"list\" command to see these roles."
+	profileUsage                = "Specifies which profile in your ~/.aws/credentials file to use when requesting the role. Also used to retrieve the role ARN from the profiles.<profile>.role_arn setting if the role or roleName flag is unspecified."
+	listUsage                   = "Lists the role names that can be passed to the roleName flag."
+	whoamiUsage                 = "Prints the identity of the current default credentials, or of the profile flag if it is set."
+	roleNameUsage               = "Specifies which role name to use from the assume.roles settings. Use \"list\" command to see these roles."
 	credsFileAwsAccessKeyId     = "aws_access_key_id"
 	credsFileAwsSecretAccessKey = "aws_secret_access_key"
 	credsFileAwsSessionToken    = "aws_session_token"
 )
 
-var roleArns = map[string]string{
-	"main": "arn:aws:iam::XXX/YYY",
+//role is a row of "assume list"
+type role struct {
+	Name string `json:"name"`
+	Arn  string `json:"arn"`
+}
+
+//callerIdentity is the result of "assume whoami"
+type callerIdentity struct {
+	Account string `json:"account"`
+	Arn     string `json:"arn"`
+	UserID  string `json:"user_id"`
 }
 
-var AssumeCmd = CmdRegistry.Cmd{
-	Name:    "assume",
-	RunCmd:  runAssume,
-	FlagSet: AssumeFlagSet,
+//assumedRole is the result of assume. The credentials themselves are only written to the credentials file.
+type assumedRole struct {
+	Role          string    `json:"role"`
+	AssumedRoleID string    `json:"assumed_role_id"`
+	Arn           string    `json:"arn"`
+	Expiration    time.Time `json:"expiration"`
+}
+
+//listCmd is the "assume list" subcommand
+type listCmd struct{}
+
+//whoamiCmd is the "assume whoami" subcommand. It uses the profile flag of the parent assume command.
+type whoamiCmd struct {
+	assume *assumeCmd
 }
 
 func init() {
-	//Set up flags
-	AssumeFlagSet = *flag.NewFlagSet("assume", flag.ContinueOnError)
-	AssumeFlagSet.Usage = func() { fmt.Print(moduleUsage) }
-	AssumeFlagSet.StringVar(&role, "role", defaultRole, roleUsage)
-	AssumeFlagSet.StringVar(&role, "r", defaultRole, "Shortcut for role")
-	AssumeFlagSet.StringVar(&profile, "profile", defaultProfile, profileUsage)
-	AssumeFlagSet.StringVar(&profile, "p", defaultProfile, "Shortcut for profile")
-	AssumeFlagSet.BoolVar(&unassume, "unassume", false, "Removes session token and resets default values to selected profile keys")
-	AssumeFlagSet.StringVar(&roleName, "roleName", "", roleNameUsage)
-	AssumeFlagSet.StringVar(&roleName, "n", "", "Shortcut for roleName")
-
-	//Register command
-	CmdRegistry.RegisterCmd(AssumeCmd)
-	CmdRegistry.RegisterFlagSet(AssumeFlagSet)
-}
-
-func runAssume() {
-	AssumeFlagSet.Parse(CmdRegistry.CmdArgs())
-
-	switch CmdRegistry.CmdArgs()[0] {
-	case "help":
-		AssumeFlagSet.PrintDefaults()
-	case "list":
-		printRoleArns()
-	default:
-		if validateArgsAndFlags() != 0 {
-			return
-		}
-		assumeRole()
+	config.Register(config.Key{Name: "profiles.*.role_arn", Usage: "Role ARN assumed for the AWS profile when no role is given", ProjectAllowed: true})
+	config.Register(config.Key{Name: "assume.roles.*", Usage: "Role ARN that can be assumed by name with the roleName flag", ProjectAllowed: true})
+	config.SetDefault("assume.roles.main", "arn:aws:iam::XXX/YYY")
+	config.Register(config.Key{Name: "assume.profile", Usage: "AWS profile used when the profile flag isn't given", ProjectAllowed: true})
+	config.Register(config.Key{Name: "assume.role", Usage: "Role ARN, or role name from assume.roles, assumed when neither the role nor the roleName flag is given", ProjectAllowed: true})
+
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "assume",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryAWS,
+		Examples: []string{"assume -p main", "assume -p main -n admin", "assume -p main -unassume"},
+		Mutating: true,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &assumeCmd{} },
+		Subcmds: []CmdRegistry.Cmd{
+			{
+				Name:  "list",
+				Usage: listUsage,
+				New:   func(CmdRegistry.Command) CmdRegistry.Command { return &listCmd{} },
+			},
+			{
+				Name:     "whoami",
+				Usage:    whoamiUsage,
+				Examples: []string{"assume whoami", "assume -p main whoami"},
+				New:      func(parent CmdRegistry.Command) CmdRegistry.Command { return &whoamiCmd{assume: parent.(*assumeCmd)} },
+			},
+		},
+	})
+}
+
+func (a *assumeCmd) Init(f *CmdRegistry.Flags) {
+	f.String(&a.role, "role", defaultRole, roleUsage).Alias("r")
+	f.String(&a.profile, "profile", config.Get("assume.profile"), profileUsage).Alias("p").Env("AWS_PROFILE").Complete(completeProfiles)
+	f.Bool(&a.unassume, "unassume", false, "Removes session token and resets default values to selected profile keys")
+	f.String(&a.roleName, "roleName", "", roleNameUsage).Alias("n").Enum(roleNames()...)
+}
+
+func (a *assumeCmd) Validate(args []string) error {
+	if a.profile == "" {
+		return CmdRegistry.Usagef("you need to specify a profile from your AWS Credentials file to use when assuming a role")
 	}
 
-	cleanUp()
+	return nil
+}
+
+func (a *assumeCmd) Run(ctx context.Context, args []string) error {
+	return a.assumeRole(ctx)
 }
 
-func printRoleArns() {
-	fmt.Println("The following roles are available.")
-	fmt.Println("[ Name to use : Corresponding Role ARN ]")
-	for key, val := range roleArns {
-		fmt.Println("[", key, ": ", val, "]")
+//Teardown closes the credentials file if the invocation opened it
+func (a *assumeCmd) Teardown() {
+	if a.credsFile != nil {
+		a.credsFile.Close()
 	}
 }
 
-func cleanUp() {
-	role = ""
-	profile = ""
-	secretAccessKey = ""
-	unassume = false
-	roleName = ""
+func (l *listCmd) Init(f *CmdRegistry.Flags) {}
+
+func (l *listCmd) Run(ctx context.Context, args []string) error {
+	roleArns := config.Sub("assume.roles")
+	roles := make([]role, 0, len(roleArns))
+	for _, name := range roleNames() {
+		roles = append(roles, role{Name: name, Arn: roleArns[name]})
+	}
+	return output.Emit(ctx, roles)
 }
 
-func validateArgsAndFlags() int {
-	if profile == "" {
-		fmt.Println("Error! You need to specify a profile from your AWS Credentials file to use when assuming a role.")
-		return 1
+func (w *whoamiCmd) Init(f *CmdRegistry.Flags) {}
+
+func (w *whoamiCmd) Run(ctx context.Context, args []string) error {
+	identity, err := utils.GetCallerIdentity(ctx, w.assume.profile)
+	if err != nil {
+		return err
 	}
+	return output.Emit(ctx, callerIdentity{
+		Account: aws.StringValue(identity.Account),
+		Arn:     aws.StringValue(identity.Arn),
+		UserID:  aws.StringValue(identity.UserId),
+	})
+}
 
-	return 0
+//contextRole returns the role of the assume.role setting, usually set by the active context, either as an ARN
+//or as a role name
+func contextRole() (string, string) {
+	role := config.Get("assume.role")
+	if strings.HasPrefix(role, "arn:") {
+		return role, ""
+	}
+	return "", role
 }
 
-func getProfile(profile string, credsFile *os.File) (string, string) {
+//roleNames returns the names of the configured roles in a stable order
+func roleNames() []string {
+	return config.Children("assume.roles")
+}
+
+func getProfile(profile string, credsFile *os.File) (string, string, error) {
 	credsFileName := credsFile.Name()
 	credsProvider := credentials.SharedCredentialsProvider{
 		Filename: credsFileName,
@@ -118,125 +180,165 @@ func getProfile(profile string, credsFile *os.File) (string, string) {
 	}
 	profileValue, err := credsProvider.Retrieve()
 	if err != nil {
-		fmt.Println("Error getting profile from %s", credsFileName)
+		return "", "", CmdRegistry.NotFoundf("error getting profile %s from %s: %w", profile, credsFileName, err)
 	}
-	return profileValue.AccessKeyID, profileValue.SecretAccessKey
+	return profileValue.AccessKeyID, profileValue.SecretAccessKey, nil
 }
 
-func getRoleArn(profile string) string {
-	configFile, err := os.Open(workingDir + "config.json")
-	if err != nil {
-		fmt.Println("Error reading config file. Please make sure that \"config.json\" exists and is readable.")
-		log.Fatal(err)
+//completeProfiles completes the profile flag with the profiles of the AWS credentials file and the profiles
+//that have a role configured
+func completeProfiles(ctx context.Context) []string {
+	seen := map[string]bool{}
+	if config, err := configparser.NewConfigParserFromFile(homeDir + "/.aws/credentials"); err == nil {
+		for _, section := range config.Sections() {
+			seen[section] = true
+		}
+	}
+	for _, profile := range config.Children("profiles") {
+		seen[profile] = true
+	}
+
+	profiles := make([]string, 0, len(seen))
+	for profile := range seen {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+func getRoleArn(ctx context.Context, profile string) (string, error) {
+	roleArn := config.Get("profiles." + profile + ".role_arn")
+	if roleArn == "" {
+		return "", CmdRegistry.NotFoundf("no role_arn configured for profile %s, set one with: config set profiles.%s.role_arn <arn>", profile, profile)
 	}
-	defer configFile.Close()
-	byteValue, _ := ioutil.ReadAll(configFile)
-	var configJson map[string]map[string]string
-	json.Unmarshal([]byte(byteValue), &configJson)
-	roleArn := configJson[profile]["role_arn"]
-	fmt.Printf("RoleArn to assume %v\n", roleArn)
-	return roleArn
+	logging.Debug(ctx, "Using the role_arn of the profile", "role", roleArn)
+	return roleArn, nil
 }
 
-func updateCreds(credsFile *os.File, keyID string, secretKey string, sessToken string) {
-	defer credsFile.Close()
+func updateCreds(ctx context.Context, credsFile *os.File, keyID string, secretKey string, sessToken string) error {
 	config, err := configparser.NewConfigParserFromFile(credsFile.Name())
 	if err != nil {
-		fmt.Println("Error reading credentials file!", err)
+		return fmt.Errorf("error reading credentials file: %w", err)
 	}
 
 	if !config.HasSection("default") {
-		fmt.Println("Creating default section in AWS credentials file and updating section.")
+		logging.Info(ctx, "Creating default section in AWS credentials file and updating section.")
 		config.AddSection("default")
 	}
 
 	err = config.Set("default", credsFileAwsAccessKeyId, keyID)
 	if err != nil {
-		fmt.Println("Error updating access key id in credentials file.")
+		return fmt.Errorf("error updating access key id in credentials file: %w", err)
 	}
 	err = config.Set("default", credsFileAwsSecretAccessKey, secretKey)
 	if err != nil {
-		fmt.Println("Error updating secret access key in credentials file.")
+		return fmt.Errorf("error updating secret access key in credentials file: %w", err)
 	}
 
 	if sessToken != "" {
 		err = config.Set("default", credsFileAwsSessionToken, sessToken)
 		if err != nil {
-			fmt.Println("Error updating session token in credentials file.")
+			return fmt.Errorf("error updating session token in credentials file: %w", err)
 		}
 	} else {
-		err = config.RemoveOption("default", credsFileAwsSessionToken)
+		config.RemoveOption("default", credsFileAwsSessionToken)
 	}
 
-	config.SaveWithDelimiter(credsFile.Name(), "=")
+	return config.SaveWithDelimiter(credsFile.Name(), "=")
 }
 
-func getCredsFile() *os.File {
+func getCredsFile(ctx context.Context) (*os.File, error) {
 	credsFile, err := os.Open(homeDir + "/.aws/credentials")
+	if os.IsNotExist(err) {
+		return nil, CmdRegistry.NotFoundf("the credentials file \"%s/.aws/credentials\" doesn't exist", homeDir)
+	}
 	if err != nil {
-		fmt.Println("Error reading config file. Please make sure that \"" + homeDir + "/.aws/credentials\" exists and is readable.")
+		return nil, fmt.Errorf("error reading credentials file, please make sure that \"%s/.aws/credentials\" is readable: %w", homeDir, err)
 	}
 
-	backupCredsFile, err := os.Open(homeDir + "/.aws/credentials.bkp")
-	if err != nil {
-		backupCredsFile, err := os.Create(homeDir + "/.aws/credentials.bkp")
-		fmt.Println("Creating backup credentials file.")
-		_, err = io.Copy(backupCredsFile, credsFile)
-		if err != nil {
-			fmt.Println("Error copying to backup credentials file.", backupCredsFile)
+	backupPath := homeDir + "/.aws/credentials.bkp"
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		logging.Info(ctx, "Creating backup credentials file.")
+		if err := backupCreds(credsFile, backupPath); err != nil {
+			logging.Warn(ctx, "Error copying to backup credentials file.", "error", err)
 		}
-		backupCredsFile.Sync()
 	}
-	defer backupCredsFile.Close()
 
-	return credsFile
+	return credsFile, nil
+
+}
 
+//backupCreds copies the credentials file to path before it is first changed
+func backupCreds(credsFile *os.File, path string) error {
+	backupCredsFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer backupCredsFile.Close()
+	if _, err := io.Copy(backupCredsFile, credsFile); err != nil {
+		return err
+	}
+	return backupCredsFile.Sync()
 }
 
-func assumeRole() {
-	if validateArgsAndFlags() != 0 { //Validate input
-		return
+func (a *assumeCmd) assumeRole(ctx context.Context) error {
+	ctx = logging.With(ctx, "profile", a.profile)
+	credsFile, err := getCredsFile(ctx)
+	if err != nil {
+		return err
+	}
+	a.credsFile = credsFile
+	profileKeyId, profileSecretKey, err := getProfile(a.profile, credsFile) //Reads credentials file to get access key based on profile input
+	if err != nil {
+		return err
 	}
-	credsFile := getCredsFile()
-	profileKeyId, profileSecretKey := getProfile(profile, credsFile) //Reads credentials file to get access key based on profile input
 
 	//If unassume flag is used, we simply update the default key values to "reset" the role
-	if unassume {
-		fmt.Println("Resetting default credentials.")
-		if profile == "" {
-			fmt.Println("Please specify the AWS profile to reset the default credentials with.")
-			return
-		}
-		updateCreds(credsFile, profileKeyId, profileSecretKey, "")
-		fmt.Println("Default credentials updated with", profile, "profile.")
-	} else {
-		if role == "" && roleName == "" {
-			fmt.Println("Using config.json to determine role to assume.")
-			role = getRoleArn(profile) //Get role arn from swap-profile config.json if no role ARN is specified
-		} else if roleName != "" {
-			role = roleArns[roleName]
-			if role == "" {
-				fmt.Println("Error! The role name you specified does not exist.")
-				return
-			}
-			fmt.Println("Assuming ", role)
-		} else if role != "" {
-			fmt.Println("Assuming ", role)
+	if a.unassume {
+		logging.Info(ctx, "Resetting default credentials.")
+		if err := updateCreds(ctx, credsFile, profileKeyId, profileSecretKey, ""); err != nil {
+			return err
 		}
+		logging.Info(ctx, fmt.Sprintf("Default credentials updated with %s profile.", a.profile))
+		utils.SetSession("", time.Time{})
+		return nil
+	}
 
-		assumeResults, err := utils.AssumeRole(role, profile, profileKeyId) //execute sts assume-role command
+	role, roleName := a.role, a.roleName
+	if role == "" && roleName == "" {
+		role, roleName = contextRole()
+	}
+	if role == "" && roleName == "" {
+		logging.Info(ctx, "Using the profile's role_arn setting to determine role to assume.")
+		role, err = getRoleArn(ctx, a.profile) //Get role arn from the config if no role ARN is specified
 		if err != nil {
-			fmt.Println("Error assuming role!", err)
+			return err
+		}
+	} else if roleName != "" {
+		role = config.Get("assume.roles." + roleName)
+		if role == "" {
+			return CmdRegistry.NotFoundf("the role name %s does not exist, use \"assume list\" to see available roles", roleName)
 		}
+	}
+	logging.Info(ctx, "Assuming role", "role", role)
 
-		fmt.Println("Role assumed!", assumeResults.AssumedRoleUser)
-		// expirationTime := assumeResults.Credentials.AccessKeyId
-		keyID := assumeResults.Credentials.AccessKeyId
-		secretKey := assumeResults.Credentials.SecretAccessKey
-		sessToken := assumeResults.Credentials.SessionToken
-		fmt.Printf("Expires at %v\n", *assumeResults.Credentials.Expiration)
-		// fmt.Printf("%v\n%v\n%v\n", *keyID, *secretKey, *sessToken)
-		updateCreds(credsFile, *keyID, *secretKey, *sessToken) //update credentials file or env var
+	assumeResults, err := utils.AssumeRole(ctx, role, a.profile, profileKeyId) //execute sts assume-role command
+	if err != nil {
+		return err
 	}
 
+	creds := assumeResults.Credentials //AssumeRole only returns without an error when the credentials are set
+	keyID := aws.StringValue(creds.AccessKeyId)
+	secretKey := aws.StringValue(creds.SecretAccessKey)
+	sessToken := aws.StringValue(creds.SessionToken)
+	if err := updateCreds(ctx, credsFile, keyID, secretKey, sessToken); err != nil { //update credentials file or env var
+		return err
+	}
+	result := assumedRole{Role: role, Expiration: aws.TimeValue(creds.Expiration)}
+	if user := assumeResults.AssumedRoleUser; user != nil {
+		result.AssumedRoleID = aws.StringValue(user.AssumedRoleId)
+		result.Arn = aws.StringValue(user.Arn)
+	}
+	utils.SetSession(result.Arn, result.Expiration) //Shown by the interactive prompt
+	return output.Emit(ctx, result)
 }
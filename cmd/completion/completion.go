@@ -0,0 +1,245 @@
+package completion
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/output"
+	"context"
+	"fmt"
+	"strings"
+)
+
+//completionCmd prints the completion script for one shell
+type completionCmd struct {
+	shell string
+}
+
+//pathInfo holds what the completion scripts need to know about one command path
+type pathInfo struct {
+	name       string
+	words      []string
+	flags      []string
+	valueFlags []string
+}
+
+const (
+	moduleUsage = "Prints a shell completion script for clitool. Flag values such as role names, clusters and EC2 tags are looked up by calling back into clitool."
+	bashUsage   = "Prints the bash completion script. Load it with: source <(clitool completion bash)"
+	zshUsage    = "Prints the zsh completion script. Load it with: source <(clitool completion zsh)"
+	fishUsage   = "Prints the fish completion script. Load it with: clitool completion fish | source"
+	programName = "clitool"
+)
+
+func init() {
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "completion",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryShell,
+		Subcmds: []CmdRegistry.Cmd{
+			{Name: "bash", Usage: bashUsage, New: newCompletionCmd("bash")},
+			{Name: "zsh", Usage: zshUsage, New: newCompletionCmd("zsh")},
+			{Name: "fish", Usage: fishUsage, New: newCompletionCmd("fish")},
+		},
+	})
+}
+
+func newCompletionCmd(shell string) func(CmdRegistry.Command) CmdRegistry.Command {
+	return func(CmdRegistry.Command) CmdRegistry.Command { return &completionCmd{shell: shell} }
+}
+
+func (c *completionCmd) Init(f *CmdRegistry.Flags) {}
+
+func (c *completionCmd) Run(ctx context.Context, args []string) error {
+	paths := collectPaths()
+	out := output.Stdout(ctx)
+	switch c.shell {
+	case "bash":
+		fmt.Fprint(out, bashScript(paths))
+	case "zsh":
+		fmt.Fprint(out, zshScript(paths))
+	case "fish":
+		fmt.Fprint(out, fishScript(paths))
+	}
+	return nil
+}
+
+//collectPaths walks the command tree and returns every command path, starting with the top level
+func collectPaths() []pathInfo {
+	top := pathInfo{words: append(CmdRegistry.Names(CmdRegistry.Cmds), CmdRegistry.Builtins...)}
+	help := pathInfo{name: "help", words: CmdRegistry.Names(CmdRegistry.Cmds)}
+	paths := []pathInfo{top, help}
+	for _, c := range CmdRegistry.Cmds {
+		paths = walk(CmdRegistry.Path{c}, paths)
+	}
+	return paths
+}
+
+func walk(path CmdRegistry.Path, paths []pathInfo) []pathInfo {
+	info := pathInfo{name: path.Name(), words: CmdRegistry.Names(path.Leaf().Subcmds)}
+	for _, flags := range path.LevelFlags() {
+		for _, spec := range flags.Specs() {
+			for _, name := range spec.Names() {
+				info.flags = append(info.flags, "-"+name)
+				if spec.TakesValue() {
+					info.valueFlags = append(info.valueFlags, "-"+name)
+				}
+			}
+		}
+	}
+	paths = append(paths, info)
+
+	for _, sub := range path.Leaf().Subcmds {
+		paths = walk(append(append(CmdRegistry.Path{}, path...), sub), paths)
+	}
+	return paths
+}
+
+//knownPaths returns the names of all command paths, used by the scripts to tell subcommands from arguments
+func knownPaths(paths []pathInfo) []string {
+	names := []string{}
+	for _, p := range paths {
+		if p.name != "" {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+func bashScript(paths []pathInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s, generated by \"%s completion bash\"\n", programName, programName)
+	fmt.Fprintf(&b, "# Load it with: source <(%s completion bash)\n\n", programName)
+	fmt.Fprintf(&b, "_clitool_paths=\"|%s|\"\n\n", strings.Join(knownPaths(paths), "|"))
+	b.WriteString(`_clitool() {
+    local cur prev path="" word i words flags valueflags
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        word="${COMP_WORDS[i]}"
+        if [[ "$_clitool_paths" == *"|${path:+$path }$word|"* ]]; then
+            path="${path:+$path }$word"
+        fi
+    done
+
+    case "$path" in
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "        %q)\n", p.name)
+		fmt.Fprintf(&b, "            words=%q\n", strings.Join(p.words, " "))
+		fmt.Fprintf(&b, "            flags=%q\n", strings.Join(p.flags, " "))
+		fmt.Fprintf(&b, "            valueflags=%q\n", "|"+strings.Join(p.valueFlags, "|")+"|")
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString(`    esac
+
+    if [[ "$valueflags" == *"|$prev|"* ]]; then
+        local IFS=$'\n'
+        COMPREPLY=($(compgen -W "$("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)" -- "$cur"))
+        return
+    fi
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "$words" -- "$cur"))
+    fi
+}
+
+complete -F _clitool clitool
+`)
+	return b.String()
+}
+
+func zshScript(paths []pathInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n", programName)
+	fmt.Fprintf(&b, "# zsh completion for %s, generated by \"%s completion zsh\"\n", programName, programName)
+	fmt.Fprintf(&b, "# Load it with: source <(%s completion zsh)\n\n", programName)
+	fmt.Fprintf(&b, "_clitool_paths=\"|%s|\"\n\n", strings.Join(knownPaths(paths), "|"))
+	b.WriteString(`_clitool() {
+    local path_="" word i words_ flags_ valueflags_
+    local cur="${words[CURRENT]}" prev="${words[CURRENT-1]}"
+    for ((i = 2; i < CURRENT; i++)); do
+        word="${words[i]}"
+        if [[ "$_clitool_paths" == *"|${path_:+$path_ }$word|"* ]]; then
+            path_="${path_:+$path_ }$word"
+        fi
+    done
+
+    case "$path_" in
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "        %q)\n", p.name)
+		fmt.Fprintf(&b, "            words_=%q\n", strings.Join(p.words, " "))
+		fmt.Fprintf(&b, "            flags_=%q\n", strings.Join(p.flags, " "))
+		fmt.Fprintf(&b, "            valueflags_=%q\n", "|"+strings.Join(p.valueFlags, "|")+"|")
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString(`    esac
+
+    if [[ "$valueflags_" == *"|$prev|"* ]]; then
+        local -a values
+        values=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+        compadd -a values
+        return
+    fi
+    if [[ "$cur" == -* ]]; then
+        compadd -- ${=flags_}
+    else
+        compadd -- ${=words_}
+    fi
+}
+
+compdef _clitool clitool
+`)
+	return b.String()
+}
+
+func fishScript(paths []pathInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s, generated by \"%s completion fish\"\n", programName, programName)
+	fmt.Fprintf(&b, "# Load it with: %s completion fish | source\n\n", programName)
+	b.WriteString("set -g __clitool_paths")
+	for _, name := range knownPaths(paths) {
+		fmt.Fprintf(&b, " '%s'", name)
+	}
+	b.WriteString(`
+
+function __clitool_complete
+    set -l tokens (commandline -opc)
+    set -l cur (commandline -ct)
+    set -l path ''
+    set -l words
+    set -l flags
+    set -l valueflags
+    for word in $tokens[2..-1]
+        set -l candidate (string trim -- "$path $word")
+        if contains -- $candidate $__clitool_paths
+            set path $candidate
+        end
+    end
+
+    switch $path
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "        case '%s'\n", p.name)
+		fmt.Fprintf(&b, "            set words %s\n", strings.Join(p.words, " "))
+		fmt.Fprintf(&b, "            set flags %s\n", strings.Join(p.flags, " "))
+		fmt.Fprintf(&b, "            set valueflags %s\n", strings.Join(p.valueFlags, " "))
+	}
+	b.WriteString(`    end
+
+    if contains -- $tokens[-1] $valueflags
+        $tokens[1] __complete $tokens[2..-1] $cur 2>/dev/null
+        return
+    end
+    if string match -q -- '-*' $cur
+        printf '%s\n' $flags
+    else
+        printf '%s\n' $words
+    end
+end
+
+complete -c clitool -f -a '(__clitool_complete)'
+`)
+	return b.String()
+}
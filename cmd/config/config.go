@@ -0,0 +1,209 @@
+package config
+
+import (
+	"clitool/utils/CmdRegistry"
+	settings "clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+//configCmd holds the flags shared by the config subcommands
+type configCmd struct {
+	project bool
+}
+
+//getCmd is the "config get" subcommand
+type getCmd struct {
+	config *configCmd
+	origin bool
+}
+
+//setCmd is the "config set" subcommand
+type setCmd struct {
+	config *configCmd
+	unset  bool
+}
+
+//listCmd is the "config list" subcommand
+type listCmd struct {
+	config *configCmd
+	all    bool
+}
+
+//setting is a row of "config list"
+type setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Origin string `json:"origin"`
+}
+
+//validateCmd is the "config validate" subcommand
+type validateCmd struct{}
+
+const (
+	moduleUsage   = "Reads and writes the settings of the CLI. Settings are layered: defaults, the user file, the project file, the active context, environment variables and -c key=value flags, each overriding the ones before it."
+	projectUsage  = "Write to the project file (" + settings.ProjectFileName + ") instead of the user file."
+	getUsage      = "Prints the value of a key. Usage: config get <key>"
+	originUsage   = "Also print the layer and file, environment variable or flag the value came from."
+	setUsage      = "Writes a key to the user file, or to the project file with -project. Usage: config set <key> <value>"
+	unsetUsage    = "Remove the key from the file instead of setting it. Usage: config set -unset <key>"
+	listUsage     = "Lists every key that is set along with where its value came from. Usage: config list [prefix]"
+	allUsage      = "Also list the keys of the schema that aren't set."
+	validateUsage = "Checks the config files and environment against the schema and reports every problem with its line."
+)
+
+func init() {
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "config",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryConfig,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &configCmd{} },
+		Subcmds: []CmdRegistry.Cmd{
+			{
+				Name:     "get",
+				Usage:    getUsage,
+				Examples: []string{"config get region", "config get -origin kssh.env"},
+				New:      func(parent CmdRegistry.Command) CmdRegistry.Command { return &getCmd{config: parent.(*configCmd)} },
+			},
+			{
+				Name:     "set",
+				Usage:    setUsage,
+				Examples: []string{"config set kssh.env prod", "config set -project elastic.env sit", "config set -unset kssh.env"},
+				Mutating: true,
+				New:      func(parent CmdRegistry.Command) CmdRegistry.Command { return &setCmd{config: parent.(*configCmd)} },
+			},
+			{
+				Name:     "list",
+				Usage:    listUsage,
+				Examples: []string{"config list", "config list -all elastic"},
+				New:      func(parent CmdRegistry.Command) CmdRegistry.Command { return &listCmd{config: parent.(*configCmd)} },
+			},
+			{
+				Name:  "validate",
+				Usage: validateUsage,
+				New:   func(CmdRegistry.Command) CmdRegistry.Command { return &validateCmd{} },
+			},
+		},
+	})
+}
+
+func (c *configCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&c.project, "project", false, projectUsage)
+}
+
+//Run prints the help of the config command, it has nothing to do without a subcommand
+func (c *configCmd) Run(ctx context.Context, args []string) error {
+	return flag.ErrHelp
+}
+
+func (g *getCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&g.origin, "origin", false, originUsage)
+}
+
+func (g *getCmd) Validate(args []string) error {
+	if len(args) != 1 {
+		return CmdRegistry.Usagef("config get expects exactly one key")
+	}
+	return nil
+}
+
+func (g *getCmd) Run(ctx context.Context, args []string) error {
+	value, ok := settings.Lookup(args[0])
+	if !ok {
+		if _, known := settings.Find(args[0]); !known {
+			return CmdRegistry.Usagef("unknown key %s", args[0])
+		}
+		return fmt.Errorf("%s is not set", args[0])
+	}
+	if g.origin {
+		return output.Emit(ctx, setting{Key: value.Key, Value: value.Value, Origin: value.Origin()})
+	}
+	_, err := fmt.Fprintln(output.Stdout(ctx), value.Value)
+	return err
+}
+
+func (s *setCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&s.unset, "unset", false, unsetUsage)
+}
+
+func (s *setCmd) Validate(args []string) error {
+	if s.unset && len(args) != 1 {
+		return CmdRegistry.Usagef("config set -unset expects exactly one key")
+	}
+	if !s.unset && len(args) != 2 {
+		return CmdRegistry.Usagef("config set expects a key and a value")
+	}
+	return nil
+}
+
+func (s *setCmd) Run(ctx context.Context, args []string) error {
+	layer := settings.LayerUser
+	if s.config.project {
+		layer = settings.LayerProject
+	}
+	path, err := settings.File(layer)
+	if err != nil {
+		return err
+	}
+
+	if s.unset {
+		if err := settings.Unset(layer, args[0]); err != nil {
+			return err
+		}
+		logging.Info(ctx, fmt.Sprintf("Removed %s from %s", args[0], path))
+		return nil
+	}
+	if err := settings.Set(layer, args[0], args[1]); err != nil {
+		return CmdRegistry.Usagef("%v", err)
+	}
+	logging.Info(ctx, fmt.Sprintf("Set %s in %s", args[0], path))
+	if value, ok := settings.Lookup(args[0]); ok && value.Layer != layer {
+		logging.Info(ctx, fmt.Sprintf("Note: %s is overridden by %s", args[0], value.Origin()))
+	}
+	return nil
+}
+
+func (l *listCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&l.all, "all", false, allUsage)
+}
+
+func (l *listCmd) Run(ctx context.Context, args []string) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	rows := []setting{}
+	for _, value := range settings.List() {
+		if strings.HasPrefix(value.Key, prefix) {
+			rows = append(rows, setting{Key: value.Key, Value: value.Value, Origin: value.Origin()})
+		}
+	}
+	if l.all {
+		for _, key := range settings.Schema() {
+			if _, ok := settings.Lookup(key.Name); !ok && strings.HasPrefix(key.Name, prefix) {
+				rows = append(rows, setting{Key: key.Name, Origin: fmt.Sprintf("(unset %s: %s)", key.Type, key.Usage)})
+			}
+		}
+	}
+	return output.Emit(ctx, rows)
+}
+
+func (v *validateCmd) Init(f *CmdRegistry.Flags) {}
+
+func (v *validateCmd) Run(ctx context.Context, args []string) error {
+	settings.Reload()
+	errs := settings.Errors()
+	for _, err := range errs {
+		fmt.Fprintln(output.Stderr(ctx), err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("found %d problem(s) in the configuration", len(errs))
+	}
+	logging.Info(ctx, "The configuration is valid.")
+	return nil
+}
@@ -0,0 +1,241 @@
+package contexts
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"context"
+	"fmt"
+	"strings"
+)
+
+//field is a setting a context can hold along with the flag of "context create" that sets it
+type field struct {
+	flag  string
+	key   string
+	usage string
+}
+
+//fields are the settings bundled by a context
+var fields = []field{
+	{"profile", "assume.profile", "AWS profile used by assume."},
+	{"role", "assume.role", "Role ARN, or role name from assume list, assumed by assume when no role flag is given."},
+	{"region", "region", "AWS region."},
+	{"kssh-env", "kssh.env", "Default environment of kssh and ksftp."},
+	{"elastic-env", "elastic.env", "Default environment of elastic."},
+}
+
+//createCmd is the "context create" subcommand
+type createCmd struct {
+	values map[string]*string
+	use    bool
+}
+
+//useCmd is the "context use" subcommand
+type useCmd struct {
+	unset bool
+}
+
+//contextRow is a row of "context list"
+type contextRow struct {
+	Current  bool              `json:"current"`
+	Name     string            `json:"name"`
+	Settings map[string]string `json:"settings"`
+}
+
+//contextSetting is a row of "context show"
+type contextSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+//listCmd is the "context list" subcommand
+type listCmd struct{}
+
+//showCmd is the "context show" subcommand
+type showCmd struct{}
+
+const (
+	moduleUsage   = "Manages named contexts. A context bundles an AWS profile, role, region and the kssh and elastic environments so they don't have to be passed as flags. Set " + config.ContextEnv + " to use another context in one terminal."
+	createUsage   = "Creates a context from the given flags. Usage: context create [flags] <name>"
+	createUseFlag = "Also make the new context the active one."
+	useUsage      = "Makes the named context the active one. Usage: context use <name>"
+	unsetUsage    = "Deactivate the current context instead. Usage: context use -unset"
+	listUsage     = "Lists the contexts, marking the active one as current."
+	showUsage     = "Prints the settings of the active context, or of the named one. Usage: context show [name]"
+)
+
+func init() {
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "context",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryConfig,
+		Subcmds: []CmdRegistry.Cmd{
+			{
+				Name:     "create",
+				Usage:    createUsage,
+				Examples: []string{"context create -profile main -role main -region us-east-1 -kssh-env prod -elastic-env prod -use prod"},
+				Mutating: true,
+				New:      func(CmdRegistry.Command) CmdRegistry.Command { return &createCmd{values: map[string]*string{}} },
+			},
+			{
+				Name:     "use",
+				Usage:    useUsage,
+				Examples: []string{"context use prod", "context use -unset"},
+				Mutating: true,
+				New:      func(CmdRegistry.Command) CmdRegistry.Command { return &useCmd{} },
+			},
+			{
+				Name:  "list",
+				Usage: listUsage,
+				New:   func(CmdRegistry.Command) CmdRegistry.Command { return &listCmd{} },
+			},
+			{
+				Name:  "show",
+				Usage: showUsage,
+				New:   func(CmdRegistry.Command) CmdRegistry.Command { return &showCmd{} },
+			},
+		},
+	})
+}
+
+func (c *createCmd) Init(f *CmdRegistry.Flags) {
+	for _, fd := range fields {
+		value := new(string)
+		c.values[fd.flag] = value
+		f.String(value, fd.flag, "", fd.usage)
+	}
+	f.Bool(&c.use, "use", false, createUseFlag)
+}
+
+func (c *createCmd) Validate(args []string) error {
+	if len(args) != 1 {
+		return CmdRegistry.Usagef("context create expects exactly one context name")
+	}
+	if exists(args[0]) {
+		return CmdRegistry.Usagef("context %s already exists, change it with: config set contexts.%s.<key> <value>", args[0], args[0])
+	}
+	for _, fd := range fields {
+		if *c.values[fd.flag] != "" {
+			return nil
+		}
+	}
+	return CmdRegistry.Usagef("a context needs at least one setting, see context create -h")
+}
+
+func (c *createCmd) Run(ctx context.Context, args []string) error {
+	name := args[0]
+	for _, fd := range fields {
+		if value := *c.values[fd.flag]; value != "" {
+			if err := config.Set(config.LayerUser, "contexts."+name+"."+fd.key, value); err != nil {
+				return err
+			}
+		}
+	}
+	logging.Info(ctx, fmt.Sprintf("Created context %s", name))
+	if c.use {
+		return useContext(ctx, name)
+	}
+	return nil
+}
+
+func (u *useCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&u.unset, "unset", false, unsetUsage)
+}
+
+func (u *useCmd) Validate(args []string) error {
+	if u.unset != (len(args) == 0) || len(args) > 1 {
+		return CmdRegistry.Usagef("context use expects either a context name or -unset")
+	}
+	if !u.unset && !exists(args[0]) {
+		return CmdRegistry.Usagef("context %s doesn't exist, use \"context list\" to see the contexts", args[0])
+	}
+	return nil
+}
+
+func (u *useCmd) Run(ctx context.Context, args []string) error {
+	if u.unset {
+		if err := config.Unset(config.LayerUser, config.ContextKey); err != nil {
+			return err
+		}
+		logging.Info(ctx, "No context is active")
+		warnOverride(ctx)
+		return nil
+	}
+	return useContext(ctx, args[0])
+}
+
+func useContext(ctx context.Context, name string) error {
+	if err := config.Set(config.LayerUser, config.ContextKey, name); err != nil {
+		return err
+	}
+	logging.Info(ctx, fmt.Sprintf("Switched to context %s", name))
+	warnOverride(ctx)
+	return nil
+}
+
+//warnOverride tells the user when the context just written to the user file is overridden, e.g. by CLITOOL_CONTEXT
+func warnOverride(ctx context.Context) {
+	if value, ok := config.Lookup(config.ContextKey); ok && value.Layer != config.LayerUser {
+		logging.Info(ctx, fmt.Sprintf("Note: the active context is %s, set by %s", value.Value, value.Origin()))
+	}
+}
+
+func (l *listCmd) Init(f *CmdRegistry.Flags) {}
+
+func (l *listCmd) Run(ctx context.Context, args []string) error {
+	active := config.Context()
+	rows := []contextRow{}
+	for _, name := range config.Children("contexts") {
+		row := contextRow{Current: name == active, Name: name, Settings: map[string]string{}}
+		for _, fd := range fields {
+			if value := config.Get("contexts." + name + "." + fd.key); value != "" {
+				row.Settings[fd.flag] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return output.Emit(ctx, rows)
+}
+
+func (s *showCmd) Init(f *CmdRegistry.Flags) {}
+
+func (s *showCmd) Run(ctx context.Context, args []string) error {
+	name := config.Context()
+	if len(args) > 0 {
+		name = args[0]
+	} else if name == "" {
+		logging.Info(ctx, "No context is active")
+		return nil
+	} else {
+		value, _ := config.Lookup(config.ContextKey)
+		logging.Info(ctx, fmt.Sprintf("Active context %s (%s)", name, value.Origin()))
+	}
+	if !exists(name) {
+		return CmdRegistry.Usagef("context %s doesn't exist", name)
+	}
+
+	rows := []contextSetting{}
+	for _, key := range config.List() {
+		if rest, ok := trimContext(key.Key, name); ok {
+			rows = append(rows, contextSetting{Key: rest, Value: key.Value})
+		}
+	}
+	return output.Emit(ctx, rows)
+}
+
+//trimContext returns the key set by a setting of the named context
+func trimContext(key string, name string) (string, bool) {
+	rest := strings.TrimPrefix(key, "contexts."+name+".")
+	return rest, rest != key
+}
+
+func exists(name string) bool {
+	for _, c := range config.Children("contexts") {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
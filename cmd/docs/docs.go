@@ -0,0 +1,322 @@
+package docs
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//docsCmd writes the reference pages of the registered commands
+type docsCmd struct {
+	format string
+	out    string
+}
+
+//page is the reference page of a top level command, with a section for it and one for every command below it
+type page struct {
+	file     string //The file name without extension, e.g. "clitool-assume"
+	category string
+	sections []section
+}
+
+//section documents one command path
+type section struct {
+	path   CmdRegistry.Path
+	parent string //The name of the command whose flags are inherited, empty for a top level command
+	flags  []*CmdRegistry.FlagSpec
+}
+
+const (
+	moduleUsage = "Writes a reference page for every command from its usage, flags and examples, as man pages or Markdown. Settings and plugins are ignored so the pages only change with the code."
+	formatUsage = "Format of the pages"
+	outUsage    = "Directory the pages are written to, created if needed"
+	programName = "clitool"
+)
+
+func init() {
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "docs",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryShell,
+		Examples: []string{"docs -format markdown -out docs", "docs -format man -out /usr/local/share/man/man1"},
+		Hidden:   true,
+		Mutating: true,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &docsCmd{} },
+	})
+}
+
+func (d *docsCmd) Init(f *CmdRegistry.Flags) {
+	f.String(&d.format, "format", "markdown", formatUsage).Alias("f").Enum("markdown", "man")
+	f.String(&d.out, "out", "docs", outUsage)
+}
+
+func (d *docsCmd) Validate(args []string) error {
+	if len(args) > 0 {
+		return CmdRegistry.Usagef("docs takes no arguments")
+	}
+	return nil
+}
+
+func (d *docsCmd) Run(ctx context.Context, args []string) error {
+	restore := config.UseDefaults() //Defaults and enums of flags may come from settings
+	pages := collectPages()
+	restore()
+
+	ext, index, render := ".md", markdownIndex, markdownPage
+	if d.format == "man" {
+		ext, index, render = ".1", manIndex, manPage
+	}
+	if err := os.MkdirAll(d.out, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.out, programName+ext), []byte(index(pages)), 0644); err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if err := ioutil.WriteFile(filepath.Join(d.out, p.file+ext), []byte(render(p)), 0644); err != nil {
+			return err
+		}
+	}
+	logging.Info(ctx, fmt.Sprintf("Wrote %d pages", len(pages)+1), "dir", d.out, "format", d.format)
+	return nil
+}
+
+//collectPages returns a page for every top level command in the order of the help overview, leaving out hidden
+//commands and plugins, which differ from one machine to the next
+func collectPages() []page {
+	pages := []page{}
+	categories, byCategory := CmdRegistry.ByCategory()
+	for _, category := range categories {
+		if category == CmdRegistry.CategoryPlugins {
+			continue
+		}
+		for _, c := range byCategory[category] {
+			p := page{file: programName + "-" + c.Name, category: category}
+			p.sections = walk(CmdRegistry.Path{c}, p.sections)
+			pages = append(pages, p)
+		}
+	}
+	return pages
+}
+
+func walk(path CmdRegistry.Path, sections []section) []section {
+	levels := path.LevelFlags()
+	flags := append([]*CmdRegistry.FlagSpec{}, levels[len(levels)-1].Specs()...)
+	sort.SliceStable(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	s := section{path: path, flags: flags}
+	if len(path) > 1 {
+		s.parent = programName + " " + path[:len(path)-1].Name()
+	}
+	sections = append(sections, s)
+	for _, sub := range path.Leaf().Subcmds {
+		if !sub.Hidden {
+			sections = walk(append(append(CmdRegistry.Path{}, path...), sub), sections)
+		}
+	}
+	return sections
+}
+
+//name returns the full command line name of the section, e.g. "clitool assume list"
+func (s section) name() string {
+	return programName + " " + s.path.Name()
+}
+
+//synopsis returns how the command is invoked, e.g. "clitool assume [flags] [subcommand]"
+func (s section) synopsis() string {
+	synopsis := s.name() + " [flags]"
+	leaf := s.path.Leaf()
+	switch {
+	case len(CmdRegistry.Names(leaf.Subcmds)) == 0:
+		synopsis += " [args]"
+	case leaf.New == nil:
+		synopsis += " <subcommand>"
+	default:
+		synopsis += " [subcommand]"
+	}
+	return synopsis
+}
+
+//flagUsage returns the usage of a flag followed by its details, e.g. `Environment (default "dev", env CLITOOL_ENV)`
+func flagUsage(spec *CmdRegistry.FlagSpec) string {
+	if details := spec.Details(); len(details) > 0 {
+		return spec.Usage + " (" + strings.Join(details, ", ") + ")"
+	}
+	return spec.Usage
+}
+
+//globalFlags returns the flags every command accepts, sorted by name
+func globalFlags() []*CmdRegistry.FlagSpec {
+	flags := append([]*CmdRegistry.FlagSpec{}, CmdRegistry.GlobalFlags().Specs()...)
+	sort.SliceStable(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+//markdownEscaper escapes the characters of usage strings that Markdown would otherwise read as formatting or HTML
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, ">", `\>`, "|", `\|`, "[", `\[`, "]", `\]`)
+
+//anchor returns the GitHub anchor of the heading of a section
+func anchor(s section) string {
+	return "#" + strings.Replace(s.name(), " ", "-", -1)
+}
+
+func markdownIndex(pages []page) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", programName)
+	fmt.Fprintf(&b, "Reference of the %s commands, generated by \"%s docs\". Run \"%s help\" for the built-in commands of the dispatcher and the interactive mode.\n", programName, programName, programName)
+	category := ""
+	for _, p := range pages {
+		if p.category != category {
+			category = p.category
+			fmt.Fprintf(&b, "\n## %s\n\n", category)
+		}
+		top := p.sections[0]
+		fmt.Fprintf(&b, "- [%s](%s.md): %s\n", top.path.Name(), p.file, markdownEscaper.Replace(top.path.Leaf().Usage))
+	}
+	return b.String()
+}
+
+func markdownPage(p page) string {
+	var b strings.Builder
+	for i, s := range p.sections {
+		heading, sub := "#", "##"
+		if i > 0 {
+			heading, sub = "##", "###"
+		}
+		leaf := s.path.Leaf()
+		fmt.Fprintf(&b, "%s %s\n\n", heading, s.name())
+		fmt.Fprintf(&b, "%s\n\n", markdownEscaper.Replace(leaf.Usage))
+		fmt.Fprintf(&b, "```\n%s\n```\n\n", s.synopsis())
+
+		if names := CmdRegistry.Names(leaf.Subcmds); len(names) > 0 {
+			fmt.Fprintf(&b, "%s Subcommands\n\n", sub)
+			for _, child := range p.sections {
+				if child.parent == s.name() {
+					fmt.Fprintf(&b, "- [%s](%s): %s\n", child.path.Leaf().Name, anchor(child), markdownEscaper.Replace(child.path.Leaf().Usage))
+				}
+			}
+			b.WriteString("\n")
+		}
+		if len(s.flags) > 0 {
+			fmt.Fprintf(&b, "%s Flags\n\n", sub)
+			for _, spec := range s.flags {
+				fmt.Fprintf(&b, "- `%s`: %s\n", spec.Label(), markdownEscaper.Replace(flagUsage(spec)))
+			}
+			b.WriteString("\n")
+		}
+		if s.parent != "" {
+			fmt.Fprintf(&b, "Also accepts the flags of [%s](%s).\n\n", s.parent, anchor(section{path: s.path[:len(s.path)-1]}))
+		}
+		if len(leaf.Examples) > 0 {
+			fmt.Fprintf(&b, "%s Examples\n\n```\n", sub)
+			for _, example := range leaf.Examples {
+				fmt.Fprintf(&b, "%s %s\n", programName, example)
+			}
+			b.WriteString("```\n\n")
+		}
+	}
+
+	b.WriteString("## Global Flags\n\n")
+	for _, spec := range globalFlags() {
+		fmt.Fprintf(&b, "- `%s`: %s\n", spec.Label(), markdownEscaper.Replace(flagUsage(spec)))
+	}
+	fmt.Fprintf(&b, "\nSee also [%s](%s.md).\n", programName, programName)
+	return b.String()
+}
+
+//roffEscaper escapes the characters of text that roff would otherwise read as requests or escapes
+var roffEscaper = strings.NewReplacer(`\`, `\e`, "-", `\-`)
+
+//roff escapes a line of text, also guarding a leading dot or quote that roff would take for a request
+func roff(s string) string {
+	s = roffEscaper.Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+//manHeader starts a man page. It has no date so that the pages only change with the commands.
+func manHeader(b *strings.Builder, title string, summary string) {
+	fmt.Fprintf(b, ".TH %s 1 \"\" \"%s\" \"%s Manual\"\n", strings.ToUpper(title), programName, programName)
+	fmt.Fprintf(b, ".SH NAME\n%s \\- %s\n", title, roff(summary))
+}
+
+//manFlags writes the flags as a tagged paragraph each
+func manFlags(b *strings.Builder, flags []*CmdRegistry.FlagSpec) {
+	for _, spec := range flags {
+		fmt.Fprintf(b, ".TP\n.B %s\n%s\n", roff(spec.Label()), roff(flagUsage(spec)))
+	}
+}
+
+//firstSentence returns the usage up to the end of its first sentence, for the NAME section of a man page
+func firstSentence(usage string) string {
+	if i := strings.Index(usage, ". "); i >= 0 {
+		return usage[:i]
+	}
+	return strings.TrimSuffix(usage, ".")
+}
+
+func manIndex(pages []page) string {
+	var b strings.Builder
+	manHeader(&b, programName, "command line interface for AWS roles, SSH and Elasticsearch")
+	fmt.Fprintf(&b, ".SH SYNOPSIS\n.B %s\n[flags] <command> [subcommand] [flags] [args]\n", programName)
+	fmt.Fprintf(&b, ".SH DESCRIPTION\nRun \\fB%s help\\fR for the built\\-in commands of the dispatcher and the interactive mode.\n", programName)
+	category := ""
+	for _, p := range pages {
+		if p.category != category {
+			category = p.category
+			fmt.Fprintf(&b, ".SH %s\n", roff(strings.ToUpper(category)))
+		}
+		top := p.sections[0]
+		fmt.Fprintf(&b, ".TP\n.BR %s (1)\n%s\n", roff(p.file), roff(top.path.Leaf().Usage))
+	}
+	return b.String()
+}
+
+func manPage(p page) string {
+	var b strings.Builder
+	top := p.sections[0]
+	manHeader(&b, p.file, firstSentence(top.path.Leaf().Usage))
+	for i, s := range p.sections {
+		leaf := s.path.Leaf()
+		if i == 0 {
+			fmt.Fprintf(&b, ".SH SYNOPSIS\n%s\n.SH DESCRIPTION\n%s\n", roff(s.synopsis()), roff(leaf.Usage))
+			if len(s.flags) > 0 {
+				b.WriteString(".SH FLAGS\n")
+			}
+		} else {
+			if i == 1 {
+				b.WriteString(".SH SUBCOMMANDS\n")
+			}
+			fmt.Fprintf(&b, ".SS %s\n%s\n.PP\n%s\n", roff(s.name()), roff(s.synopsis()), roff(leaf.Usage))
+			if s.parent != "" {
+				fmt.Fprintf(&b, ".PP\nAlso accepts the flags of \\fB%s\\fR.\n", roff(s.parent))
+			}
+		}
+		manFlags(&b, s.flags)
+		if len(leaf.Examples) > 0 {
+			if i == 0 {
+				b.WriteString(".SH EXAMPLES\n")
+			} else {
+				b.WriteString(".PP\nExamples:\n")
+			}
+			b.WriteString(".PP\n.RS\n.nf\n")
+			for _, example := range leaf.Examples {
+				fmt.Fprintf(&b, "%s %s\n", programName, roff(example))
+			}
+			b.WriteString(".fi\n.RE\n")
+		}
+	}
+
+	b.WriteString(".SH GLOBAL FLAGS\n")
+	manFlags(&b, globalFlags())
+	fmt.Fprintf(&b, ".SH SEE ALSO\n.BR %s (1)\n", programName)
+	return b.String()
+}
@@ -2,25 +2,24 @@ package elastic
 
 import (
 	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"context"
 	"encoding/csv"
 	"encoding/json"
-	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
-var ElasticCmd = CmdRegistry.Cmd{
-	Name:    "elastic",
-	RunCmd:  runCmd,
-	FlagSet: ElasticFlagSet,
-}
-
 type HitSource struct {
 	Target string `json:"member"`
 }
@@ -47,20 +46,44 @@ type envelopeResponse struct {
 	}
 }
 
-var clusters map[string]string
-var env string
-var ElasticFlagSet flag.FlagSet
-var index string
+//indicesCmd is the "elastic indices" subcommand. It queries the clusters of the parent's environment.
+type indicesCmd struct {
+	elastic *elasticCmd
+}
+
+//elasticCmd holds the flags of a single elastic invocation
+type elasticCmd struct {
+	clusters     map[string]string
+	env          string
+	index        string
+	clusterNames []string
+	out          string
+}
+
+//hit is a row of the elastic command
+type hit struct {
+	Cluster string `json:"cluster"`
+	ID      string `json:"id"`
+	Target  string `json:"target"`
+}
+
+//csvHeader names the columns of the file written with the out flag, see record
+var csvHeader = []string{"cluster", "id", "target"}
 
-var sitClusters = map[string]string{
-	"example": "https://example.us-east-1.es.amazonaws.com",
+//record returns the hit as a row of the file written with the out flag
+func (h hit) record(source HitSource) []string {
+	return append([]string{h.Cluster, h.ID}, source.ToSlice()...)
 }
 
 const (
 	cmdUsage     = "Queries the specified elastic search cluster for data from targeted transactions"
 	envUsage     = "Specifes which environment clusters to query."
+	envVar       = "CLITOOL_ELASTIC_ENV"
+	clusterUsage = "Only query the named cluster of the environment instead of all of them."
 	indexUsage   = "Specifies the index in the elasticSearch cluster from which to query"
 	indexDefault = "default-index"
+	indicesUsage = "Lists the indices of every cluster in the environment"
+	outUsage     = "Also write the hits to this CSV file as they arrive, so an interrupted query keeps the ones received."
 	query        = `{
 			"query": {
 			  "bool": {
@@ -81,54 +104,263 @@ const (
 		  }`
 )
 
+//clearScrollTimeout bounds how long releasing a scroll may take once the query is over
+const clearScrollTimeout = 5 * time.Second
+
 func init() {
-	ElasticFlagSet = *flag.NewFlagSet("elastic", flag.ContinueOnError)
-	ElasticFlagSet.Usage = func() { fmt.Print(cmdUsage) }
-	ElasticFlagSet.StringVar(&env, "env", "envSample", envUsage)
-	ElasticFlagSet.StringVar(&env, "e", "envSample", "shortcut for environment")
-	ElasticFlagSet.StringVar(&index, "index", indexDefault, indexUsage)
-	CmdRegistry.RegisterCmd(ElasticCmd)
-	CmdRegistry.RegisterFlagSet(ElasticFlagSet)
+	config.Register(config.Key{Name: "elastic.env", Usage: "Default environment of the elastic command, one of the environments of elastic.clusters", Default: "sit", ChoicesFrom: "elastic.clusters", ProjectAllowed: true})
+	config.Register(config.Key{Name: "elastic.clusters.*.*", Usage: "Address of a cluster by environment and cluster name", ProjectAllowed: true})
+	config.SetDefault("elastic.clusters.sit.example", "https://example.us-east-1.es.amazonaws.com")
+
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "elastic",
+		Usage:    cmdUsage,
+		Category: CmdRegistry.CategorySearch,
+		Examples: []string{"elastic -e prod -index logs", "elastic -e sit -cluster example -index logs", "elastic -e prod -index logs -out hits.csv"},
+		Mutating: true,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &elasticCmd{} },
+		Subcmds: []CmdRegistry.Cmd{
+			{
+				Name:     "indices",
+				Usage:    indicesUsage,
+				Examples: []string{"elastic indices -e prod", "elastic indices -e prod -o json"},
+				New: func(parent CmdRegistry.Command) CmdRegistry.Command {
+					return &indicesCmd{elastic: parent.(*elasticCmd)}
+				},
+			},
+		},
+	})
 }
 
-func cleanUp() {
-	clusters = map[string]string{}
-	env = ""
+func (e *elasticCmd) Init(f *CmdRegistry.Flags) {
+	f.String(&e.env, "env", config.Get("elastic.env"), envUsage).Alias("e").Enum(envNames()...).Env(envVar)
+	f.String(&e.index, "index", indexDefault, indexUsage).Complete(e.completeIndices)
+	f.Strings(&e.clusterNames, "cluster", clusterUsage).Complete(e.completeClusters)
+	f.String(&e.out, "out", "", outUsage)
 }
 
-func validateFlagsAndArgs() int {
-	if env == "envSample" {
-		fmt.Println("Environment set to sit")
-		clusters = sitClusters
-	} else {
-		fmt.Println("Error. Environment not set to valid value")
-		return 1
-	}
+//completeClusters completes the cluster flag with the clusters of the environment typed so far
+func (e *elasticCmd) completeClusters(ctx context.Context) []string {
+	return config.Children("elastic.clusters." + e.env)
+}
 
-	fmt.Println("Using index", index)
+func (e *elasticCmd) Validate(args []string) error {
+	e.clusters = envClusters(e.env)
+	if len(e.clusterNames) > 0 {
+		selected := map[string]string{}
+		for _, name := range e.clusterNames {
+			address, ok := e.clusters[name]
+			if !ok {
+				return CmdRegistry.Usagef("cluster %s does not exist in environment %s", name, e.env)
+			}
+			selected[name] = address
+		}
+		e.clusters = selected
+	}
 
-	return 0
+	return nil
 }
 
-//RunCmd is the entrypoint into the elastic command execution
-func runCmd() {
-	ElasticFlagSet.Parse(CmdRegistry.CmdArgs())
-	if validateFlagsAndArgs() != 0 {
-		return
+//Run is the entrypoint into the elastic command execution. Clusters are queried concurrently; a cluster that
+//fails doesn't stop the others and the first failure is returned once they are all done. The hits are emitted
+//as rows grouped by cluster, along with the ones of the clusters that failed to finish.
+func (e *elasticCmd) Run(ctx context.Context, args []string) error {
+	logging.Info(ctx, "Environment set to "+e.env)
+	logging.Info(ctx, "Using index "+e.index)
+
+	var writer *csv.Writer
+	if e.out != "" {
+		file, err := os.Create(e.out)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %w", e.out, err)
+		}
+		defer file.Close()
+		writer = csv.NewWriter(file)
+		writeToFile(writer, csvHeader)
 	}
 
 	var wg *sync.WaitGroup = new(sync.WaitGroup)
-	wg.Add(len(clusters))
-	for i, v := range clusters {
-		go executeQuery(i, v, wg)
+	var errMu sync.Mutex
+	errs := []error{}
+	hits := map[string][]hit{}
+	wg.Add(len(e.clusters))
+	for i, v := range e.clusters {
+		go func(member string, clusterAddress string) {
+			defer wg.Done()
+			ctx := logging.With(ctx, "cluster", member)
+			var found []hit
+			err := func() (err error) {
+				defer func() { //A panic in a goroutine can't be recovered by the dispatcher and would end the session
+					if r := recover(); r != nil {
+						err = fmt.Errorf("query of %s crashed: %v", member, r)
+					}
+				}()
+				found, err = e.executeQuery(ctx, member, clusterAddress, writer)
+				return err
+			}()
+			if err != nil && len(e.clusters) > 1 && ctx.Err() == nil {
+				logging.Error(ctx, CmdRegistry.Describe(err))
+			}
+			errMu.Lock()
+			hits[member] = found
+			if err != nil {
+				errs = append(errs, err)
+			}
+			errMu.Unlock()
+		}(i, v)
 	}
 	wg.Wait()
 
+	if writer != nil {
+		if err := flushFile(writer); err != nil {
+			errs = append(errs, fmt.Errorf("error writing %s: %w", e.out, err))
+		} else if ctx.Err() != nil {
+			logging.Info(ctx, "Query interrupted, "+e.out+" holds the results received so far")
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	members := make([]string, 0, len(hits))
+	for member := range hits {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	rows := []hit{}
+	for _, member := range members {
+		rows = append(rows, hits[member]...)
+	}
+	if err := output.Emit(ctx, rows); err != nil {
+		return err
+	}
+
+	if len(errs) > 1 {
+		return fmt.Errorf("%d of %d clusters failed, the first one with: %w", len(errs), len(e.clusters), errs[0])
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (i *indicesCmd) Init(f *CmdRegistry.Flags) {}
+
+//envNames returns the environments that have clusters configured in a stable order
+func envNames() []string {
+	return config.Children("elastic.clusters")
+}
+
+//envClusters returns the addresses of the clusters of an environment by name
+func envClusters(env string) map[string]string {
+	return config.Sub("elastic.clusters." + env)
+}
+
+func (i *indicesCmd) Validate(args []string) error {
+	return i.elastic.Validate(args)
+}
+
+//index is a row of "elastic indices"
+type index struct {
+	Cluster string `json:"cluster"`
+	Index   string `json:"index"`
+}
+
+//Run lists the indices of every cluster in the environment, sorted by cluster
+func (i *indicesCmd) Run(ctx context.Context, args []string) error {
+	logging.Info(ctx, "Environment set to "+i.elastic.env)
+	members := make([]string, 0, len(i.elastic.clusters))
+	for member := range i.elastic.clusters {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	rows := []index{}
+	for _, member := range members {
+		indices, err := listIndices(ctx, member, i.elastic.clusters[member])
+		if err != nil {
+			return err
+		}
+		for _, name := range indices {
+			rows = append(rows, index{Cluster: member, Index: name})
+		}
+	}
+	return output.Emit(ctx, rows)
+}
+
+//completeIndices completes the index flag with the indices of the clusters typed so far, or of every
+//cluster in the environment
+func (e *elasticCmd) completeIndices(ctx context.Context) []string {
+	clusters := envClusters(e.env)
+	seen := map[string]bool{}
+	for member, clusterAddress := range clusters {
+		if len(e.clusterNames) > 0 && !contains(e.clusterNames, member) {
+			continue
+		}
+		indices, err := listIndices(ctx, member, clusterAddress)
+		if err != nil {
+			continue
+		}
+		for _, index := range indices {
+			seen[index] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for index := range seen {
+		names = append(names, index)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//listIndices returns the names of the indices of one cluster
+func listIndices(ctx context.Context, member string, clusterAddress string) ([]string, error) {
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{clusterAddress}})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the client for %s: %w", member, err)
+	}
+
+	res, err := es.Cat.Indices(
+		es.Cat.Indices.WithContext(ctx),
+		es.Cat.Indices.WithFormat("json"),
+		es.Cat.Indices.WithH("index"),
+	)
+	if err != nil {
+		return nil, CmdRegistry.Remotef("error listing indices of %s: %w", member, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res, "listing indices of "+member)
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return nil, fmt.Errorf("error reading indices of %s: %w", member, err)
+	}
+	names := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		names = append(names, idx.Index)
+	}
+	return names, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
-func executeQuery(member string, clusterAddress string, wg *sync.WaitGroup) {
+//executeQuery returns the hits of the query on one cluster, also writing them to writer unless it is nil. The hits
+//received before an error are returned along with it.
+func (e *elasticCmd) executeQuery(ctx context.Context, member string, clusterAddress string, writer *csv.Writer) ([]hit, error) {
 	start := time.Now()
-	fmt.Println("Querying cluster", member)
+	logging.Info(ctx, "Querying cluster "+member)
 
 	//Configure elasticsearch go client
 	cfg := elasticsearch.Config{
@@ -138,8 +370,7 @@ func executeQuery(member string, clusterAddress string, wg *sync.WaitGroup) {
 	}
 	es, err := elasticsearch.NewClient(cfg)
 	if err != nil {
-		log.Fatalf("Error creating the client: %s", err)
-		return
+		return nil, fmt.Errorf("error creating the client for %s: %w", member, err)
 	}
 
 	//Build filter query for search command
@@ -149,82 +380,125 @@ func executeQuery(member string, clusterAddress string, wg *sync.WaitGroup) {
 
 	//Search for 1000 rows and then set the scroll
 	res, err := es.Search(
-		es.Search.WithIndex(index),
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(e.index),
 		es.Search.WithSize(1000),
 		es.Search.WithBody(read),
 		es.Search.WithScroll(time.Minute),
 		es.Search.WithPretty(),
 	)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		log.Fatalf("Error getting data from index: %s", err)
+		return nil, CmdRegistry.Remotef("error querying %s: %w", member, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res, "querying index "+e.index+" of "+member)
 	}
-	// fmt.Println(res)
 
 	//Decode initial results
 	var r envelopeResponse
 	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		log.Fatalf("Error getting data from index: %s", err)
+		return nil, CmdRegistry.Remotef("error reading the results of %s: %w", member, err)
 	}
-	defer res.Body.Close()
 
-	file, err := os.Create("output.csv")
-	if err != nil {
-		fmt.Println("Error creating output.csv", err)
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-	writer.Write([]string{"target"})
-
-	//Write initial results
-	for _, data := range r.Hits.Hits {
-		// fmt.Println(data.Source.ToSlice())
-		writer.Write(data.Source.ToSlice())
-		// writeToFile(writer, data.Source.ToSlice())
-	}
+	//Keep initial results
+	hits := collect(member, &r, nil, writer)
 
 	//Scroll over remaining rows until there are none left
 	scrollID := r.ScrollID
+	defer func() { clearScroll(ctx, es, scrollID) }() //Frees the scroll on the cluster even when interrupted
 	for {
 		//Execute scroll
-		scroll_res, err := es.Scroll(
-			es.Scroll.WithScroll(time.Minute),
-			es.Scroll.WithScrollID(scrollID),
-			es.Scroll.WithPretty(),
-		)
-		if err != nil {
-			fmt.Println("Error executing scroll!", err)
-		}
-		if scroll_res.IsError() {
-			log.Fatalf("Error with scroll! %s", scroll_res)
+		rs, err := scroll(ctx, es, scrollID)
+		if ctx.Err() != nil {
+			return hits, ctx.Err()
 		}
-		// fmt.Println(scroll_res)
-
-		//Decode initial results
-		var rs envelopeResponse
-		if err := json.NewDecoder(scroll_res.Body).Decode(&rs); err != nil {
-			log.Fatalf("Error reolving data: %s", err)
+		if err != nil {
+			return hits, fmt.Errorf("error scrolling %s: %w", member, err)
 		}
 
 		//End scrolling
 		if len(rs.Hits.Hits) < 1 {
-			fmt.Printf("\nQuery for %s completed. Time taken: %v", member, time.Since(start))
+			logging.Info(ctx, fmt.Sprintf("Query for %s completed. Time taken: %v", member, time.Since(start)))
 			break
 		}
 
-		//Write results
-		for _, data := range rs.Hits.Hits {
-			// fmt.Println(data.Source.ToSlice())
-			writer.Write(data.Source.ToSlice())
-			// writeToFile(writer, data.Source.ToSlice())
-		}
+		//Keep results
+		hits = collect(member, rs, hits, writer)
 		scrollID = rs.ScrollID
 	}
+	return hits, nil
+}
+
+//collect appends the hits of a page of results of a cluster to hits, writing them to writer unless it is nil
+func collect(member string, r *envelopeResponse, hits []hit, writer *csv.Writer) []hit {
+	for _, data := range r.Hits.Hits {
+		h := hit{Cluster: member, ID: data.ID, Target: data.Source.Target}
+		if writer != nil {
+			writeToFile(writer, h.record(data.Source))
+		}
+		hits = append(hits, h)
+	}
+	return hits
+}
+
+//scroll fetches the next page of results of a scroll
+func scroll(ctx context.Context, es *elasticsearch.Client, scrollID string) (*envelopeResponse, error) {
+	res, err := es.Scroll(
+		es.Scroll.WithContext(ctx),
+		es.Scroll.WithScroll(time.Minute),
+		es.Scroll.WithScrollID(scrollID),
+		es.Scroll.WithPretty(),
+	)
+	if err != nil {
+		return nil, CmdRegistry.Remotef("%w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, responseError(res, "scrolling")
+	}
+
+	var rs envelopeResponse
+	if err := json.NewDecoder(res.Body).Decode(&rs); err != nil {
+		return nil, CmdRegistry.Remotef("error reading results: %w", err)
+	}
+	return &rs, nil
+}
 
-	wg.Done()
+//clearScroll releases a scroll on the cluster. It runs after the query, possibly once ctx was cancelled, so the
+//request gets a few seconds of its own and ctx is only used for logging.
+func clearScroll(ctx context.Context, es *elasticsearch.Client, scrollID string) {
+	if scrollID == "" {
+		return
+	}
+	clearCtx, cancel := context.WithTimeout(context.Background(), clearScrollTimeout)
+	defer cancel()
+	res, err := es.ClearScroll(
+		es.ClearScroll.WithContext(clearCtx),
+		es.ClearScroll.WithScrollID(scrollID),
+	)
+	if err != nil {
+		logging.Debug(ctx, "Error clearing scroll", "error", err)
+		return
+	}
+	res.Body.Close()
 }
 
+//responseError classifies an error response of a cluster by its status
+func responseError(res *esapi.Response, action string) error {
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CmdRegistry.Authf("error %s: %s", action, res.Status())
+	case http.StatusNotFound:
+		return CmdRegistry.NotFoundf("error %s: %s", action, res.Status())
+	}
+	return CmdRegistry.Remotef("error %s: %s", action, res.Status())
+}
+
+//mu serializes the writes of the clusters, which are queried concurrently, to the file of the out flag
 var mu sync.Mutex
 
 func writeToFile(writer *csv.Writer, data []string) {
@@ -232,3 +506,11 @@ func writeToFile(writer *csv.Writer, data []string) {
 	defer mu.Unlock()
 	writer.Write(data)
 }
+
+//flushFile writes what is buffered for the file of the out flag and returns the first error of its writes
+func flushFile(writer *csv.Writer) error {
+	mu.Lock()
+	defer mu.Unlock()
+	writer.Flush()
+	return writer.Error()
+}
@@ -4,133 +4,268 @@ import (
 	"bufio"
 	utils "clitool/utils"
 	"clitool/utils/CmdRegistry"
-	"flag"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"context"
+	"errors"
 	"fmt"
-	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-var KsshCmd = CmdRegistry.Cmd{
-	Name:    "kssh",
-	RunCmd:  func() { RunMssh(false) },
-	FlagSet: KsshFlagSet,
+//ksshCmd holds the flags of a single kssh or ksftp invocation
+type ksshCmd struct {
+	withSftp   bool
+	targetName string
+	env        string
+	app        string
+	tags       []string
 }
 
-var KsftpCmd = CmdRegistry.Cmd{
-	Name:    "ksftp",
-	RunCmd:  func() { RunMssh(true) },
-	FlagSet: KsshFlagSet,
+const (
+	envUsage    = "Specify the environment to query about."
+	envVar      = "CLITOOL_ENV"
+	TargetUsage = "Specify the target data to query about."
+	appUsage    = "Application to query about. (Frontend, database, etc.)"
+	tagUsage    = "Additional tag filter in Key=Value form."
+	moduleUsage = "The KSSH/KSFTP command will execute the MSSH or MSFTP for the configured user (kssh.user, ubuntu by default) against the Instance ID specified by the command arguments."
+	ksftpUsage  = "Same usage as kssh but executes MSFTP instead of MSSH"
+	listUsage   = "Lists the running instances matching the env flag and the target, app and tag flags given, without connecting to them."
+)
+
+//instance is a row of "kssh list"
+type instance struct {
+	ID        string `json:"id"`
+	PrivateIP string `json:"private_ip"`
+	Name      string `json:"name"`
 }
 
-var KsshFlagSet flag.FlagSet
-var TargetName string
-var env string
-var app string
+//listCmd is the "kssh list" subcommand. Its filters come from the flags of the parent kssh command.
+type listCmd struct {
+	kssh *ksshCmd
+}
 
-const (
-	defaultEnv    = "DEV"
-	envUsage      = "Specify the environment to query about."
-	defaultTarget = "ExampleTarget"
-	TargetUsage   = "Specify the target data to query about."
-	defaultApp    = "ExampleApp"
-	appUsage      = "Application to query about. (Frontend, database, etc.)"
-	moduleUsage   = "The KSSH/KSFTP command will execute the MSSH or MSFTP for the Ubuntu user against the Instance ID specified by the command arguments."
-)
+var listSubcmd = CmdRegistry.Cmd{
+	Name:     "list",
+	Usage:    listUsage,
+	Examples: []string{"kssh list -e prod -t green -a api", "kssh list -e prod -t green -a api -o json"},
+	New:      func(parent CmdRegistry.Command) CmdRegistry.Command { return &listCmd{kssh: parent.(*ksshCmd)} },
+}
 
 func init() {
-	KsshFlagSet = *flag.NewFlagSet("kssh", flag.ContinueOnError)
-	KsshFlagSet.Usage = func() { fmt.Print(moduleUsage) }
-	KsshFlagSet.StringVar(&TargetName, "target", defaultTarget, TargetUsage)
-	KsshFlagSet.StringVar(&TargetName, "t", defaultTarget, "Shorthand -Target")
-	KsshFlagSet.StringVar(&env, "env", defaultEnv, envUsage)
-	KsshFlagSet.StringVar(&env, "e", defaultEnv, "Shorthand -env")
-	KsshFlagSet.StringVar(&app, "app", defaultApp, appUsage)
-	KsshFlagSet.StringVar(&app, "a", defaultApp, "Shorthand -app")
-	CmdRegistry.RegisterCmd(KsshCmd)
-	CmdRegistry.RegisterCmd(KsftpCmd)
-	CmdRegistry.RegisterFlagSet(KsshFlagSet)
+	config.Register(config.Key{Name: "kssh.env", Usage: "Default environment of the kssh and ksftp commands", Default: "dev", Choices: envs, ProjectAllowed: true})
+	config.Register(config.Key{Name: "kssh.user", Usage: "User to connect to instances as", Default: "ubuntu", ProjectAllowed: true})
+
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "kssh",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryAWS,
+		Examples: []string{`kssh -t green -a "My App"`, "kssh -e prod -t green -a api -tag Role=worker"},
+		Mutating: true,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &ksshCmd{} },
+		Subcmds:  []CmdRegistry.Cmd{listSubcmd},
+	})
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "ksftp",
+		Usage:    ksftpUsage,
+		Category: CmdRegistry.CategoryAWS,
+		Examples: []string{`ksftp -t green -a "My App"`},
+		Mutating: true,
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &ksshCmd{withSftp: true} },
+		Subcmds:  []CmdRegistry.Cmd{listSubcmd},
+	})
+}
+
+//envs are the values of the Environment tag that instances can be filtered on
+var envs = []string{"dev", "sit", "prod"}
+
+func (k *ksshCmd) Init(f *CmdRegistry.Flags) {
+	f.String(&k.targetName, "target", "", TargetUsage).Alias("t").Required().Complete(tagValues("Target"))
+	f.String(&k.env, "env", config.Get("kssh.env"), envUsage).Alias("e").Enum(envs...).Env(envVar).Complete(discoveredEnvs)
+	f.String(&k.app, "app", "", appUsage).Alias("a").Required().Complete(tagValues("AppName"))
+	f.Strings(&k.tags, "tag", tagUsage)
 }
 
-func validateArgsAndFlags() int {
-	if KsshFlagSet.Arg(0) == "help" {
-		KsshFlagSet.PrintDefaults()
-		return 1
+//tagValues completes a flag with the values of an EC2 instance tag
+func tagValues(key string) CmdRegistry.CompleteFunc {
+	return func(ctx context.Context) []string {
+		values, err := utils.GetTagValues(ctx, key)
+		if err != nil {
+			return nil
+		}
+		return values
 	}
+}
 
-	if TargetName == "" {
-		fmt.Println("ERROR: Target name flag not set.")
-		return 1
+//discoveredEnvs completes the env flag with the environments that instances are actually tagged with
+func discoveredEnvs(ctx context.Context) []string {
+	found := []string{}
+	for _, value := range tagValues("Environment")(ctx) {
+		for _, env := range envs {
+			if strings.EqualFold(env, value) {
+				found = append(found, env)
+			}
+		}
 	}
+	return found
+}
+
+func (k *ksshCmd) Validate(args []string) error {
+	return k.validateTags()
+}
 
-	return 0
+func (k *ksshCmd) validateTags() error {
+	for _, tag := range k.tags {
+		if !strings.Contains(tag, "=") {
+			return CmdRegistry.Usagef("tag filter %s is not in Key=Value form", tag)
+		}
+	}
+
+	return nil
 }
 
-func RunMssh(withSftp bool) {
-	KsshFlagSet.Parse(CmdRegistry.CmdArgs())
-	if validateArgsAndFlags() != 0 {
-		return
+//Run looks up the instance matching the given flags and executes mssh, or msftp for ksftp, against it
+func (k *ksshCmd) Run(ctx context.Context, args []string) error {
+	logging.Info(ctx, fmt.Sprintf("Getting instance ID for %v %v in %v", k.targetName, k.app, k.env))
+	reservationList, err := k.getReservations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var iid string
+	ids := instanceIDs(reservationList)
+	if len(ids) > 1 {
+		iid, err = getUserInput(ctx, ids)
+		if err != nil {
+			return err
+		}
+	} else if len(ids) == 1 {
+		iid = ids[0]
+	} else {
+		return CmdRegistry.NotFoundf("no running instances found for %v %v in %v", k.targetName, k.app, k.env)
+	}
+
+	ctx = logging.With(ctx, "instance", iid)
+	logging.Info(ctx, "Instance ID: "+iid)
+	cmdString := fmt.Sprintf("%v@%v", config.Get("kssh.user"), iid) //Execute mssh command using Instance ID from previous step
+	program := "mssh"
+	if k.withSftp {
+		program = "msftp"
+	}
+	logging.Info(ctx, "Executing "+program+"...")
+	cmd := exec.CommandContext(ctx, program, cmdString)
+	cmd.Stderr = output.Stderr(ctx)
+	cmd.Stdout = output.Stdout(ctx)
+	cmd.Stdin = output.Stdin(ctx)
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return CmdRegistry.NotFoundf("%s is not installed or not on your PATH: %w", program, err)
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return err
 	}
+	return nil
+}
 
-	fmt.Printf("Getting instance ID for %v %v in %v\n", TargetName, app, env)
+//getReservations describes the running instances matching the env flag and the target, app and tag flags that
+//were given
+func (k *ksshCmd) getReservations(ctx context.Context) ([]*ec2.Reservation, error) {
 	describeFilter := []*ec2.Filter{
 		{Name: aws.String("instance-state-name"), Values: []*string{aws.String("running")}},
-		{Name: aws.String("tag:Target"), Values: []*string{aws.String(strings.Title(strings.ToLower(TargetName)))}},
-		{Name: aws.String("tag:AppName"), Values: []*string{aws.String(app)}},
-		{Name: aws.String("tag:Environment"), Values: []*string{aws.String(strings.ToUpper(env))}},
+		{Name: aws.String("tag:Environment"), Values: []*string{aws.String(strings.ToUpper(k.env))}},
+	}
+	if k.targetName != "" {
+		describeFilter = append(describeFilter, &ec2.Filter{Name: aws.String("tag:Target"), Values: []*string{aws.String(strings.Title(strings.ToLower(k.targetName)))}})
+	}
+	if k.app != "" {
+		describeFilter = append(describeFilter, &ec2.Filter{Name: aws.String("tag:AppName"), Values: []*string{aws.String(k.app)}})
+	}
+	for _, tag := range k.tags {
+		kv := strings.SplitN(tag, "=", 2)
+		describeFilter = append(describeFilter, &ec2.Filter{Name: aws.String("tag:" + kv[0]), Values: []*string{aws.String(kv[1])}})
 	}
-	descOutput, descErr := utils.GetInstances(describeFilter)
+	descOutput, descErr := utils.GetInstances(ctx, describeFilter)
 	if descErr != nil {
-		fmt.Println("Error getting instance information!", descErr)
-		return
+		return nil, descErr
 	}
+	return descOutput.Reservations, nil
+}
 
-	reservationList := descOutput.Reservations
-	var iid string
-	resLen := len(reservationList)
-	if resLen > 1 {
-		iid = getUserInput(reservationList)
-	} else if resLen == 1 {
-		iid = aws.StringValue(reservationList[0].Instances[0].InstanceId)
-	} else if resLen == 0 {
-		fmt.Println("No instances found!")
-		return
-	} else {
-		fmt.Println("Failed to get an instance ID.")
-		return
+func (l *listCmd) Init(f *CmdRegistry.Flags) {}
+
+func (l *listCmd) Validate(args []string) error {
+	return l.kssh.validateTags()
+}
+
+//Run lists the instances matching the parent's filters
+func (l *listCmd) Run(ctx context.Context, args []string) error {
+	reservationList, err := l.kssh.getReservations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(reservationList) == 0 {
+		logging.Warn(ctx, "No instances found!")
 	}
 
-	fmt.Printf("Instance ID: \n%v\n", iid)
-	cmdString := fmt.Sprintf("ubuntu@%v", iid) //Execute mssh command using Instance ID from previous step
-	var cmd *exec.Cmd
-	if withSftp == true {
-		fmt.Println("Executing msftp...")
-		cmd = exec.Command("msftp", cmdString)
-	} else {
-		fmt.Println("Executing mssh...")
-		cmd = exec.Command("mssh", cmdString)
+	instances := []instance{}
+	for _, reservation := range reservationList {
+		for _, i := range reservation.Instances {
+			instances = append(instances, instance{
+				ID:        aws.StringValue(i.InstanceId),
+				PrivateIP: aws.StringValue(i.PrivateIpAddress),
+				Name:      instanceName(i),
+			})
+		}
 	}
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-	cmd.Run()
+	return output.Emit(ctx, instances)
 }
 
-func getUserInput(reservationList []*ec2.Reservation) string {
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Multiple instance IDs found. Please select one.")
-	for index, value := range reservationList {
-		fmt.Printf("\n[%v]: %v", index, aws.StringValue(value.Instances[0].InstanceId))
+//instanceName returns the value of the Name tag of the instance
+func instanceName(instance *ec2.Instance) string {
+	for _, tag := range instance.Tags {
+		if aws.StringValue(tag.Key) == "Name" {
+			return aws.StringValue(tag.Value)
+		}
 	}
-	fmt.Printf("\n->")
+	return ""
+}
 
-	input, _, err := reader.ReadRune()
-	if err != nil {
-		fmt.Println("Error processing input!", err)
+//instanceIDs returns the IDs of the instances of every reservation
+func instanceIDs(reservationList []*ec2.Reservation) []string {
+	ids := []string{}
+	for _, reservation := range reservationList {
+		for _, instance := range reservation.Instances {
+			ids = append(ids, aws.StringValue(instance.InstanceId))
+		}
 	}
+	return ids
+}
 
-	return aws.StringValue(reservationList[int(input-'0')].Instances[0].InstanceId)
+//getUserInput asks the user to pick one of the instance IDs by its index
+func getUserInput(ctx context.Context, ids []string) (string, error) {
+	reader := bufio.NewReader(output.Stdin(ctx))
+	stderr := output.Stderr(ctx)
+	fmt.Fprintln(stderr, "Multiple instance IDs found. Please select one.")
+	for index, id := range ids {
+		fmt.Fprintf(stderr, "\n[%v]: %v", index, id)
+	}
+	fmt.Fprintf(stderr, "\n->")
+
+	input, err := reader.ReadString('\n')
+	if ctx.Err() != nil { //Reading stdin can't be interrupted, so Ctrl-C applies once the line is entered
+		return "", ctx.Err()
+	}
+	if err != nil && input == "" {
+		return "", fmt.Errorf("error reading the selection: %w", err)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || index < 0 || index >= len(ids) {
+		return "", CmdRegistry.Usagef("invalid selection %q, expected a number from 0 to %d", strings.TrimSpace(input), len(ids)-1)
+	}
+	return ids[index], nil
 }
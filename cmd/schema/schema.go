@@ -0,0 +1,153 @@
+package schema
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/output"
+	"context"
+	"sort"
+)
+
+//version is the version of the schema format, increased whenever a field changes meaning or is removed
+const version = 1
+
+//schemaCmd prints the schema of the registered commands
+type schemaCmd struct {
+	hidden bool
+}
+
+//document is the description of the commands printed by "schema"
+type document struct {
+	Version     int        `json:"version"`
+	Program     string     `json:"program"`
+	Commands    []command  `json:"commands"`
+	GlobalFlags []flagDesc `json:"global_flags"`
+}
+
+//command describes a command and the commands below it. A subcommand also accepts the flags of every command above it.
+type command struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Usage    string `json:"usage"`
+	Category string `json:"category,omitempty"`
+	//Runnable is false for commands that only group their subcommands
+	Runnable bool `json:"runnable"`
+	Mutating bool `json:"mutating"`
+	Hidden   bool `json:"hidden,omitempty"`
+	//RawArgs is set for commands that take their arguments untouched, without flags
+	RawArgs     bool       `json:"raw_args,omitempty"`
+	Examples    []string   `json:"examples,omitempty"`
+	Flags       []flagDesc `json:"flags"`
+	Subcommands []command  `json:"subcommands,omitempty"`
+}
+
+//flagDesc describes one flag. The values of a Dynamic flag are looked up with "clitool __complete".
+type flagDesc struct {
+	Name       string   `json:"name"`
+	Aliases    []string `json:"aliases,omitempty"`
+	Type       string   `json:"type"`
+	Usage      string   `json:"usage"`
+	Default    string   `json:"default,omitempty"`
+	Enum       []string `json:"enum,omitempty"`
+	Env        string   `json:"env,omitempty"`
+	Required   bool     `json:"required,omitempty"`
+	Repeatable bool     `json:"repeatable,omitempty"`
+	Sensitive  bool     `json:"sensitive,omitempty"`
+	Dynamic    bool     `json:"dynamic,omitempty"`
+}
+
+const (
+	moduleUsage = "Prints a JSON description of every command: its subcommands, flags, types, enums, defaults and whether it changes state, for tools that build forms or completions. Pass a command name to only describe that command."
+	hiddenUsage = "Also describe the hidden commands"
+	programName = "clitool"
+)
+
+func init() {
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:     "schema",
+		Usage:    moduleUsage,
+		Category: CmdRegistry.CategoryShell,
+		Examples: []string{"schema", "schema kssh", "schema -o yaml"},
+		New:      func(CmdRegistry.Command) CmdRegistry.Command { return &schemaCmd{} },
+	})
+}
+
+func (s *schemaCmd) Init(f *CmdRegistry.Flags) {
+	f.Bool(&s.hidden, "hidden", false, hiddenUsage)
+}
+
+func (s *schemaCmd) Validate(args []string) error {
+	if len(args) > 1 {
+		return CmdRegistry.Usagef("schema takes at most one command name")
+	}
+	if len(args) == 1 {
+		if _, ok := CmdRegistry.Lookup(args[0]); !ok {
+			return CmdRegistry.NotFoundf("no command %q.%s", args[0], CmdRegistry.DidYouMean(args[0], CmdRegistry.Names(CmdRegistry.Cmds), ""))
+		}
+	}
+	return nil
+}
+
+func (s *schemaCmd) Run(ctx context.Context, args []string) error {
+	doc := document{Version: version, Program: programName, Commands: []command{}, GlobalFlags: flags(CmdRegistry.GlobalFlags())}
+	for _, c := range CmdRegistry.Cmds {
+		if (len(args) == 0 && (!c.Hidden || s.hidden)) || (len(args) == 1 && c.Name == args[0]) {
+			doc.Commands = append(doc.Commands, s.describe(CmdRegistry.Path{c}))
+		}
+	}
+
+	streams := output.FromContext(ctx)
+	if streams.Format.Name == output.Table || streams.Format.Name == output.TSV {
+		streams.Format = output.Format{Name: output.JSON} //Rows can't hold the command tree
+	}
+	return output.Emit(output.WithStreams(ctx, streams), doc)
+}
+
+func (s *schemaCmd) describe(path CmdRegistry.Path) command {
+	leaf := path.Leaf()
+	levels := path.LevelFlags()
+	c := command{
+		Name:     leaf.Name,
+		Path:     path.Name(),
+		Usage:    leaf.Usage,
+		Category: leaf.Category,
+		Runnable: leaf.New != nil,
+		Mutating: leaf.Mutating,
+		Hidden:   leaf.Hidden,
+		RawArgs:  leaf.RawArgs,
+		Examples: leaf.Examples,
+		Flags:    flags(levels[len(levels)-1]),
+	}
+	for _, sub := range leaf.Subcmds {
+		if !sub.Hidden || s.hidden {
+			c.Subcommands = append(c.Subcommands, s.describe(append(append(CmdRegistry.Path{}, path...), sub)))
+		}
+	}
+	return c
+}
+
+//flags describes the declared flags sorted by name
+func flags(f *CmdRegistry.Flags) []flagDesc {
+	specs := append([]*CmdRegistry.FlagSpec{}, f.Specs()...)
+	sort.SliceStable(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	described := make([]flagDesc, 0, len(specs))
+	for _, spec := range specs {
+		fl := flagDesc{
+			Name:       spec.Name,
+			Aliases:    spec.Aliases,
+			Type:       spec.Type,
+			Usage:      spec.Usage,
+			Default:    spec.Default,
+			Enum:       spec.Choices,
+			Env:        spec.EnvVar,
+			Required:   spec.IsRequired,
+			Repeatable: spec.Repeatable,
+			Sensitive:  spec.IsSensitive,
+			Dynamic:    spec.Dynamic(),
+		}
+		if spec.IsSensitive {
+			fl.Default = "" //Like help, never show the value of a secret
+		}
+		described = append(described, fl)
+	}
+	return described
+}
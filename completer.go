@@ -0,0 +1,41 @@
+package main
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/logging"
+	"clitool/utils/shell"
+	"context"
+	"fmt"
+	"strings"
+)
+
+//replCompleter completes command names, subcommands, flags and flag values at the interactive prompt
+type replCompleter struct{}
+
+//Do implements readline.AutoCompleter. It returns the suffixes that complete the word under the cursor
+//and the length of the part of that word that was already typed.
+func (replCompleter) Do(line []rune, pos int) (suffixes [][]rune, length int) {
+	defer func() { //Completion runs on the goroutine of readline, where a panic would end the session
+		if r := recover(); r != nil {
+			logging.Debug(context.Background(), fmt.Sprint("Completion failed: ", r))
+			suffixes, length = nil, 0
+		}
+	}()
+
+	words, typed, ok := shell.Partial(string(line[:pos])) //Only the last command of a chain is completed
+	if !ok {
+		return nil, 0
+	}
+	cur := words[len(words)-1]
+
+	candidates := CmdRegistry.Complete(context.Background(), expandAlias(words))
+	suffixes = make([][]rune, 0, len(candidates))
+	for _, candidate := range candidates {
+		suffix := strings.TrimPrefix(candidate, cur)
+		if !strings.HasSuffix(candidate, "=") {
+			suffix += " "
+		}
+		suffixes = append(suffixes, []rune(suffix))
+	}
+	return suffixes, typed
+}
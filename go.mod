@@ -1,6 +1,6 @@
 module clitool
 
-go 1.13
+go 1.17
 
 require (
 	github.com/aws/aws-sdk-go v1.30.7
@@ -8,3 +8,9 @@ require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/elastic/go-elasticsearch/v8 v8.0.0-20200408073057-6f36a473b19f
 )
+
+require (
+	github.com/chzyer/test v1.0.0 // indirect
+	github.com/jmespath/go-jmespath v0.3.0 // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+)
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/output"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	config.Register(config.Key{Name: "history.file", Usage: "File of the interactive history, by default history in the state directory", Env: []string{"CLITOOL_HISTORY_FILE"}})
+	config.Register(config.Key{Name: "history.size", Type: config.TypeInt, Usage: "Number of lines kept in the interactive history", Default: "1000"})
+}
+
+//historyFile returns the path of the history file, creating its directory readable by the user only. The file
+//itself is created, or its permissions tightened, with mode 0600 so that no other user of a shared host can
+//read it.
+func historyFile() (string, error) {
+	path := config.Get("history.file")
+	if path == "" {
+		path = filepath.Join(config.StateDir(), "history")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return path, os.Chmod(path, 0600)
+}
+
+//historySize returns the number of lines kept in the history
+func historySize() int {
+	size, err := strconv.Atoi(config.Get("history.size"))
+	if err != nil || size <= 0 {
+		return 1000
+	}
+	return size
+}
+
+//readHistory returns the lines of the history file, oldest first
+func readHistory() ([]string, error) {
+	path, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+var (
+	//secretAssignment matches settings of AWS secrets, e.g. AWS_SECRET_ACCESS_KEY=... or aws_session_token = ...
+	secretAssignment = regexp.MustCompile(`(?i)((?:aws_)?(?:secret_access_key|session_token|security_token)\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s;&|]+)`)
+	//base64Run matches runs of base64 characters, in which secret access keys and session tokens are looked for
+	base64Run = regexp.MustCompile(`[A-Za-z0-9/+]+=*`)
+)
+
+//redact replaces the values of sensitive flags, see CmdRegistry.FlagSpec.Sensitive, and anything that looks
+//like an AWS secret access key or session token in a line before it is saved to the history
+func redact(line string) string {
+	if names := CmdRegistry.SensitiveFlags(); len(names) > 0 {
+		for i, name := range names {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		flags := regexp.MustCompile(`(^|\s)(--?(?:` + strings.Join(names, "|") + `)(?:=|\s+))("[^"]*"|'[^']*'|[^\s;&|]+)`)
+		line = flags.ReplaceAllString(line, "${1}${2}"+CmdRegistry.Redacted)
+	}
+	line = secretAssignment.ReplaceAllString(line, "${1}"+CmdRegistry.Redacted)
+	return base64Run.ReplaceAllStringFunc(line, func(s string) string {
+		if len(s) >= 100 || (len(strings.TrimRight(s, "=")) == 40 && isMixed(s)) {
+			return CmdRegistry.Redacted //A session token or a secret access key
+		}
+		return s
+	})
+}
+
+//isMixed reports whether s has upper and lower case letters, which tells a secret access key apart from a
+//hexadecimal hash of the same length such as a git commit
+func isMixed(s string) bool {
+	return strings.ToLower(s) != s && strings.ToUpper(s) != s
+}
+
+//expandHistory replaces a line made of a history reference with the line it refers to: !n for line n of the
+//history, !-n for the nth line before the last, !! for the last line and !text for the last line starting
+//with text
+func expandHistory(line string) (string, bool, error) {
+	ref := strings.TrimSpace(line)
+	if len(ref) < 2 || ref[0] != '!' || strings.ContainsAny(ref, " \t") {
+		return line, false, nil
+	}
+	history, err := readHistory()
+	if err != nil {
+		return "", false, err
+	}
+
+	ref = ref[1:]
+	if ref == "!" {
+		ref = "-1"
+	}
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 0 {
+			n += len(history) + 1
+		}
+		if n < 1 || n > len(history) {
+			return "", false, CmdRegistry.NotFoundf("no history line %s", ref)
+		}
+		return history[n-1], true, nil
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if strings.HasPrefix(history[i], ref) {
+			return history[i], true, nil
+		}
+	}
+	return "", false, CmdRegistry.NotFoundf("no history line starts with %q", ref)
+}
+
+//processHistory prints the history with the number to replay each line with, only the lines containing the
+//text given as arguments if any
+func processHistory(ctx context.Context, args []string) int {
+	history, err := readHistory()
+	if err != nil {
+		return fail(ctx, err)
+	}
+	search := strings.ToLower(strings.Join(args, " "))
+	for i, line := range history {
+		if strings.Contains(strings.ToLower(line), search) {
+			fmt.Fprintf(output.Stdout(ctx), "%5d  %s\n", i+1, line)
+		}
+	}
+	return CmdRegistry.ExitOK
+}
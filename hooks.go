@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/plugin"
+	"clitool/utils/shell"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	config.Register(config.Key{Name: "hooks.pre", Usage: "Hook run before every command, see hooks.*.pre. Hooks run commands, so they are only read from the user file, its contexts and -c flags, never from a project file."})
+	config.Register(config.Key{Name: "hooks.post", Usage: "Hook run after every command, see hooks.*.post. Hooks run commands, so they are only read from the user file, its contexts and -c flags, never from a project file."})
+	config.Register(config.Key{Name: "hooks.*.pre", Usage: "Hook run before the command and its subcommands: a clitool command line, or a local shell command after a !. A failing pre hook keeps the command from running. Hooks run commands, so they are only read from the user file, its contexts and -c flags, never from a project file."})
+	config.Register(config.Key{Name: "hooks.*.post", Usage: "Hook run after the command and its subcommands: a clitool command line, or a local shell command after a !. Hooks run commands, so they are only read from the user file, its contexts and -c flags, never from a project file."})
+	CmdRegistry.Hooks.Before = runPreHooks
+	CmdRegistry.Hooks.After = runPostHooks
+}
+
+//hookEvent tells a hook about the command it runs around. A local shell hook reads it as JSON on stdin and a
+//clitool hook refers to its fields as $hook.<field>, e.g. $hook.flags.env.
+type hookEvent struct {
+	Hook    string            `json:"hook"`    //"pre" or "post"
+	Command string            `json:"command"` //e.g. "kssh list"
+	Flags   map[string]string `json:"flags"`   //Sensitive flags are left out
+	Args    []string          `json:"args"`
+	Status  int               `json:"status"` //The exit status of the command, 0 for a pre hook
+	Error   string            `json:"error,omitempty"`
+}
+
+//hookLine returns the hook set under key. A project file comes with whatever directory the CLI runs in, so a hook
+//it sets is ignored even if it got past the config, which already refuses it.
+func hookLine(key string) string {
+	v, ok := config.Lookup(key)
+	if !ok || v.Layer == config.LayerProject {
+		return ""
+	}
+	return v.Value
+}
+
+//hookingKey is the context key set while a hook runs. Commands run by a hook don't run hooks themselves.
+type hookingKey struct{}
+
+//runPreHooks runs hooks.pre, then the pre hook of the command. A hook that fails refuses the command.
+func runPreHooks(ctx context.Context, inv CmdRegistry.Invocation) error {
+	if ctx.Value(hookingKey{}) != nil {
+		return nil
+	}
+	event := hookEvent{Hook: "pre", Command: inv.Path.Name(), Flags: inv.Flags, Args: inv.Args}
+	for _, key := range []string{"hooks.pre", "hooks." + inv.Path[0].Name + ".pre"} {
+		line := hookLine(key)
+		if line == "" {
+			continue
+		}
+		if status := runHook(ctx, line, event); status != CmdRegistry.ExitOK {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%s refused to run %s, it exited with status %d", key, event.Command, status)
+		}
+	}
+	return nil
+}
+
+//runPostHooks runs the post hook of the command, then hooks.post. A hook that fails is only reported.
+func runPostHooks(ctx context.Context, inv CmdRegistry.Invocation, err error) {
+	if ctx.Value(hookingKey{}) != nil {
+		return
+	}
+	event := hookEvent{Hook: "post", Command: inv.Path.Name(), Flags: inv.Flags, Args: inv.Args, Status: CmdRegistry.ExitCode(err)}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	for _, key := range []string{"hooks." + inv.Path[0].Name + ".post", "hooks.post"} {
+		line := hookLine(key)
+		if line == "" {
+			continue
+		}
+		if status := runHook(ctx, line, event); status != CmdRegistry.ExitOK {
+			logging.Warn(ctx, fmt.Sprintf("%s exited with status %d", key, status))
+		}
+	}
+}
+
+//runHook runs a hook and returns its exit status. A line starting with ! is run by the local shell, any other
+//line is run like an interactive line. The output of hooks goes to stderr so that the output of the command stays
+//clean, unless they redirect it.
+func runHook(ctx context.Context, line string, event hookEvent) int {
+	streams := output.FromContext(ctx)
+	streams.Out = streams.Err
+	ctx = context.WithValue(output.WithStreams(ctx, streams), hookingKey{}, true)
+	if strings.HasPrefix(line, "!") {
+		return runShellHook(ctx, strings.TrimPrefix(line, "!"), event)
+	}
+
+	cmds, err := shell.Parse(line)
+	if err != nil {
+		return fail(ctx, CmdRegistry.Usagef("invalid hook %q: %v", line, err))
+	}
+	//The commands of a hook leave $? and $_ alone, see runCommands and recordResults
+	status, _ := runCommands(ctx, cmds, func(name string) (string, bool) {
+		if strings.HasPrefix(name, "hook.") {
+			value, _ := output.Field(event, strings.TrimPrefix(name, "hook."))
+			return value, true //A field the event doesn't have expands to nothing
+		}
+		return lookupVar(name)
+	})
+	return status
+}
+
+//runShellHook runs a hook with the local shell. It gets the event as JSON on stdin and in CLITOOL_HOOK_*
+//environment variables, along with the environment plugins get.
+func runShellHook(ctx context.Context, line string, event hookEvent) int {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fail(ctx, err)
+	}
+	flags, _ := json.Marshal(event.Flags)
+
+	sh := shell.Local(line)
+	sh.Env = append(plugin.Environ(ctx),
+		"CLITOOL_HOOK="+event.Hook,
+		"CLITOOL_HOOK_COMMAND="+event.Command,
+		"CLITOOL_HOOK_FLAGS="+string(flags),
+		"CLITOOL_HOOK_ARGS="+shell.Quote(event.Args),
+		"CLITOOL_HOOK_STATUS="+strconv.Itoa(event.Status),
+	)
+	for name, value := range event.Flags { //e.g. CLITOOL_HOOK_FLAG_KSSH_ENV for -kssh-env
+		sh.Env = append(sh.Env, "CLITOOL_HOOK_FLAG_"+strings.ToUpper(strings.Replace(name, "-", "_", -1))+"="+value)
+	}
+	streams := output.FromContext(ctx)
+	sh.Stdin = bytes.NewReader(append(data, '\n'))
+	sh.Stdout, sh.Stderr = streams.Out, streams.Err
+	if streams.Background {
+		shell.Detach(sh)
+	}
+	if err := sh.Start(); err != nil {
+		return fail(ctx, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- sh.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		sh.Process.Kill()
+		<-done
+		return CmdRegistry.ExitCancelled
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return fail(ctx, err)
+	}
+	return CmdRegistry.ExitOK
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/logging"
+	"context"
+	"os"
+	"os/signal"
+)
+
+//interruptible returns a context that is cancelled when the user presses Ctrl-C while a command runs, along with
+//the function that stops listening once the command is over. A second Ctrl-C exits the CLI, in case a command
+//doesn't stop when cancelled.
+//
+//While readline waits for input the terminal is in raw mode and Ctrl-C is read as a key instead, so it never
+//reaches this handler.
+func interruptible(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-sigs:
+			logging.Debug(ctx, "Interrupted, cancelling the command")
+			cancel()
+		}
+		select {
+		case <-done:
+		case <-sigs:
+			logging.Error(ctx, "Interrupted again, exiting")
+			exit(CmdRegistry.ExitCancelled)
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel()
+	}
+}
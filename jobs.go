@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//job is a list of commands ended by "&", running in the background with its output captured
+type job struct {
+	id     int
+	line   string
+	cancel context.CancelFunc
+	done   chan struct{}
+	status int
+	out    *jobOutput
+}
+
+//jobOutput captures the output of a job. Once attached by fg, writes are also copied to the terminal.
+type jobOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   io.Writer
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.Write(p)
+	if o.w != nil {
+		o.w.Write(p)
+	}
+	return len(p), nil
+}
+
+//empty reports whether the job wrote nothing
+func (o *jobOutput) empty() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Len() == 0
+}
+
+//attach writes the output captured so far to w and copies later output to it, until attached to nil
+func (o *jobOutput) attach(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if w != nil {
+		w.Write(o.buf.Bytes())
+	}
+	o.w = w
+}
+
+var (
+	jobsMu sync.Mutex
+	jobs   []*job
+	nextID = 1
+	//jobsCtx is the context jobs run in. It isn't cancelled by Ctrl-C at the prompt, which only stops the
+	//command in the foreground.
+	jobsCtx = context.Background()
+	//notify prints the notice of a finished job. Interactive mode prints it above the prompt.
+	notify = func(msg string) { fmt.Fprintln(os.Stderr, msg) }
+)
+
+//startJob runs a list of commands in the background and returns right away
+func startJob(cmds []shell.Command) *job {
+	args := make([]string, len(cmds))
+	for i, c := range cmds {
+		args[i] = c.String()
+		if i > 0 {
+			args[i] = map[shell.Op]string{shell.OpAnd: "&& ", shell.OpOr: "|| "}[c.Op] + args[i]
+		}
+	}
+
+	ctx, cancel := context.WithCancel(jobsCtx)
+	jobsMu.Lock()
+	j := &job{id: nextID, line: strings.Join(args, " "), cancel: cancel, done: make(chan struct{}), out: &jobOutput{}}
+	nextID++
+	jobs = append(jobs, j)
+	jobsMu.Unlock()
+
+	ctx = output.WithStreams(ctx, output.Streams{In: strings.NewReader(""), Out: j.out, Err: j.out, Background: true})
+	ctx = logging.With(ctx, "job", j.id)
+	go func() {
+		status := CmdRegistry.ExitOK
+		j.status = shell.Run(cmds, status, func(c shell.Command) int {
+			if ctx.Err() != nil {
+				return CmdRegistry.ExitCancelled
+			}
+			status = runExpanded(ctx, c, status, lookupVar, false)
+			return status
+		})
+		cancel()
+		close(j.done)
+		//The notice reports the end of the job, which is then forgotten unless it has output left for fg to show
+		if j.status == CmdRegistry.ExitCancelled || j.out.empty() {
+			removeJob(j)
+			notify(fmt.Sprintf("[%d] %s  %s", j.id, j.state(), j.line))
+		} else {
+			notify(fmt.Sprintf("[%d] %s  %s (fg %d shows its output)", j.id, j.state(), j.line, j.id))
+		}
+	}()
+	return j
+}
+
+//state describes whether the job is running or how it ended
+func (j *job) state() string {
+	select {
+	case <-j.done:
+	default:
+		return "Running"
+	}
+	switch j.status {
+	case CmdRegistry.ExitOK:
+		return "Done"
+	case CmdRegistry.ExitCancelled:
+		return "Cancelled"
+	}
+	return fmt.Sprintf("Exit %d", j.status)
+}
+
+//findJob returns the job of a "%1" or "1" argument, or the latest job when there are no arguments
+func findJob(args []string) (*job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if len(args) == 0 {
+		if len(jobs) == 0 {
+			return nil, CmdRegistry.NotFoundf("there are no jobs")
+		}
+		return jobs[len(jobs)-1], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "%"))
+	if err != nil || len(args) > 1 {
+		return nil, CmdRegistry.Usagef("expected a job number, e.g. 1 or %%1")
+	}
+	for _, j := range jobs {
+		if j.id == id {
+			return j, nil
+		}
+	}
+	return nil, CmdRegistry.NotFoundf("no job %d", id)
+}
+
+func removeJob(j *job) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for i, other := range jobs {
+		if other == j {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+//runningJobs returns the number of jobs that haven't finished
+func runningJobs() int {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	running := 0
+	for _, j := range jobs {
+		if j.state() == "Running" {
+			running++
+		}
+	}
+	return running
+}
+
+//cancelJobs cancels every job and waits for them to stop, so that no plugin of a job outlives the CLI
+func cancelJobs() {
+	jobsMu.Lock()
+	running := append([]*job{}, jobs...)
+	jobsMu.Unlock()
+	for _, j := range running {
+		j.cancel()
+		<-j.done
+	}
+}
+
+//processJobs lists the jobs with their state
+func processJobs(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		return fail(ctx, CmdRegistry.Usagef("jobs takes no arguments"))
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, j := range jobs {
+		fmt.Fprintf(output.Stdout(ctx), "[%d] %-9s %s\n", j.id, j.state(), j.line)
+	}
+	return CmdRegistry.ExitOK
+}
+
+//processFg brings a job to the foreground: it prints the output captured so far and follows it until the job
+//is done. Ctrl-C cancels the job. The job is then forgotten and its exit status becomes the status of fg.
+func processFg(ctx context.Context, args []string) int {
+	j, err := findJob(args)
+	if err != nil {
+		return fail(ctx, err)
+	}
+	fmt.Fprintln(output.Stderr(ctx), j.line)
+	j.out.attach(output.Stdout(ctx))
+	defer j.out.attach(nil)
+
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		j.cancel()
+		<-j.done
+	}
+	removeJob(j)
+	return j.status
+}
+
+//processKill cancels a job, which is forgotten once the notice of its end is shown
+func processKill(ctx context.Context, args []string) int {
+	j, err := findJob(args)
+	if err != nil {
+		return fail(ctx, err)
+	}
+	j.cancel()
+	return CmdRegistry.ExitOK
+}
+
+//processWait waits for the given job, or for every job, to finish and returns the exit status of the last one.
+//The jobs waited for are then forgotten. Ctrl-C stops waiting without cancelling the jobs.
+func processWait(ctx context.Context, args []string) int {
+	waitFor := []*job{}
+	if len(args) > 0 {
+		j, err := findJob(args)
+		if err != nil {
+			return fail(ctx, err)
+		}
+		waitFor = append(waitFor, j)
+	} else {
+		jobsMu.Lock()
+		waitFor = append(waitFor, jobs...)
+		jobsMu.Unlock()
+	}
+
+	status := CmdRegistry.ExitOK
+	for _, j := range waitFor {
+		select {
+		case <-j.done:
+			status = j.status
+			removeJob(j)
+		case <-ctx.Done():
+			return CmdRegistry.ExitCancelled
+		}
+	}
+	return status
+}
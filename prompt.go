@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"clitool/utils"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+//defaultPrompt shows the active context, the assumed role with the time left before it expires and the
+//environment, e.g. "clitool(prod) admin@123456789012 42m prod> "
+const defaultPrompt = `{{color .Color "clitool"}}{{with .Context}}({{color "yellow" .}}){{end}}` +
+	`{{with .Role}} {{.}}@{{$.Account}}{{with $.Expires}} {{.}}{{end}}{{end}} {{.Env}}> `
+
+func init() {
+	config.Register(config.Key{Name: "prompt.template", Usage: "Go template of the interactive prompt, see the README for its fields", Default: defaultPrompt})
+	config.Register(config.Key{Name: "prompt.production", Type: config.TypeList, Usage: "Environments that turn the prompt red", Default: "prod"})
+	config.Register(config.Key{Name: "prompt.expiry_warning", Type: config.TypeDuration, Usage: "Time left before the assumed role expires that turns the prompt red", Default: "10m"})
+}
+
+//promptData are the fields available to the prompt template
+type promptData struct {
+	Context    string
+	Profile    string
+	Region     string
+	Env        string //The environment of kssh
+	ElasticEnv string
+	Role       string //The role last assumed in this session, or else the identity of the active credentials
+	Account    string
+	Expires    string //The time left before the role expires, e.g. "1h05m", or "expired", empty when unknown
+	Production bool   //Env or ElasticEnv is listed in prompt.production
+	Expiring   bool   //The role expires within prompt.expiry_warning
+	Color      string //"red" when Production or Expiring, "blue" otherwise
+}
+
+//colors are the ANSI codes of the colors accepted by the color function of the prompt template
+var colors = map[string]string{"bold": "1", "red": "31", "green": "32", "yellow": "33", "blue": "34", "magenta": "35", "cyan": "36"}
+
+var promptFuncs = template.FuncMap{
+	"color": func(name string, s string) string {
+		if code, ok := colors[name]; ok && s != "" {
+			return "\033[" + code + "m" + s + "\033[0m"
+		}
+		return s
+	},
+	"setting": config.Get,
+}
+
+//promptWarning reports an invalid prompt template only once rather than at every line
+var promptWarning sync.Once
+
+//prompt returns the interactive prompt rendered from the prompt.template setting, falling back to the default
+//template when it is invalid
+func prompt() string {
+	data := newPromptData(time.Now())
+	text := config.Get("prompt.template")
+	s, err := renderPrompt(text, data)
+	if err != nil {
+		promptWarning.Do(func() {
+			logging.Warn(context.Background(), "Invalid prompt.template setting, using the default prompt: "+err.Error())
+		})
+		s, _ = renderPrompt(defaultPrompt, data)
+	}
+	return s
+}
+
+//refreshPrompt redraws the prompt while waiting for input whenever it changes, e.g. when the expiry countdown
+//goes down a minute
+func refreshPrompt(rl *readline.Instance) {
+	last := prompt()
+	for range time.Tick(15 * time.Second) {
+		if p := prompt(); p != last {
+			last = p
+			rl.SetPrompt(p)
+			rl.Refresh()
+		}
+	}
+}
+
+func renderPrompt(text string, data promptData) (string, error) {
+	tmpl, err := template.New("prompt").Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func newPromptData(now time.Time) promptData {
+	data := promptData{
+		Context:    config.Context(),
+		Profile:    config.Get("assume.profile"),
+		Region:     utils.Region(),
+		Env:        config.Get("kssh.env"),
+		ElasticEnv: config.Get("elastic.env"),
+		Color:      "blue",
+	}
+	for _, env := range strings.Split(config.Get("prompt.production"), ",") {
+		if env = strings.TrimSpace(env); env != "" && (env == data.Env || env == data.ElasticEnv) {
+			data.Production = true
+		}
+	}
+
+	session, ok := utils.ActiveSession()
+	if ok {
+		data.Role, data.Account = session.Role, session.Account
+	}
+	if ok && !session.Expiration.IsZero() {
+		left := session.Expiration.Sub(now)
+		data.Expires = formatLeft(left)
+		warning, err := time.ParseDuration(config.Get("prompt.expiry_warning"))
+		if err != nil {
+			warning = 10 * time.Minute
+		}
+		data.Expiring = left < warning
+	}
+	if data.Production || data.Expiring {
+		data.Color = "red"
+	}
+	return data
+}
+
+//formatLeft formats the time left before expiry in minutes, e.g. "42m" or "1h05m"
+func formatLeft(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "expired"
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%dh%02dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
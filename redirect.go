@@ -0,0 +1,86 @@
+package main
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+//processRedirected runs a command with its output sent to the file or local shell command of its redirection,
+//so that every command supports "> file", ">> file" and "| grep ..." without handling them itself. Only the
+//output goes there, log messages still go to the terminal. The status of a pipe is the status of the local
+//shell command, like in a shell.
+func processRedirected(ctx context.Context, c shell.Command) int {
+	streams := output.FromContext(ctx)
+	switch c.Redirect {
+	case shell.RedirectFile, shell.RedirectAppend:
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if c.Redirect == shell.RedirectAppend {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(c.Target, flags, 0644)
+		if err != nil {
+			return fail(ctx, err)
+		}
+		streams.Out = f
+		code := processCmd(output.WithStreams(ctx, streams), c.Args[0], c.Args[1:])
+		if err := f.Close(); err != nil {
+			return fail(ctx, err)
+		}
+		return code
+
+	case shell.RedirectPipe:
+		sh := shell.Local(c.Target)
+		sh.Stdout = streams.Out
+		sh.Stderr = streams.Err
+		if streams.Background {
+			shell.Detach(sh)
+		}
+		in, err := sh.StdinPipe()
+		if err != nil {
+			return fail(ctx, err)
+		}
+		if err := sh.Start(); err != nil {
+			return fail(ctx, err)
+		}
+		streams.Out = &pipeWriter{w: in}
+		processCmd(output.WithStreams(ctx, streams), c.Args[0], c.Args[1:])
+		in.Close()
+		if ctx.Err() != nil {
+			sh.Process.Kill()
+		}
+		err = sh.Wait()
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil:
+			return CmdRegistry.ExitCancelled
+		case errors.As(err, &exitErr):
+			return exitErr.ExitCode()
+		case err != nil:
+			return fail(ctx, err)
+		}
+		return CmdRegistry.ExitOK
+	}
+	return processCmd(ctx, c.Args[0], c.Args[1:])
+}
+
+//pipeWriter discards what is written once the local shell command stopped reading, e.g. after "| head -1",
+//instead of failing the command with a broken pipe
+type pipeWriter struct {
+	w      io.Writer
+	closed bool
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	if !p.closed {
+		if _, err := p.w.Write(b); err != nil {
+			p.closed = true
+		}
+	}
+	return len(b), nil
+}
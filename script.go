@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//Script options, changed with "set -e" and "set -x" or the flags of the run builtin
+var errexit bool
+var xtrace bool
+
+//processRun runs the script file given in args. "-" reads the script from stdin.
+func processRun(ctx context.Context, args []string) int {
+	var stopOnError, trace bool
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.BoolVar(&stopOnError, "e", false, "Stop at the first failing command")
+	fs.BoolVar(&trace, "x", false, "Print each command before running it")
+	if err := CmdRegistry.ParseFlags(fs, args); err != nil {
+		return fail(ctx, err)
+	}
+	if fs.NArg() != 1 {
+		return fail(ctx, CmdRegistry.Usagef("run expects exactly one script file"))
+	}
+
+	//Options set by the script don't outlive it
+	defer func(e, x bool) { errexit, xtrace = e, x }(errexit, xtrace)
+	errexit = errexit || stopOnError
+	xtrace = xtrace || trace
+
+	name := fs.Arg(0)
+	if name == "-" {
+		return runScript(ctx, os.Stdin, "stdin")
+	}
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return fail(ctx, CmdRegistry.NotFoundf("%w", err))
+	}
+	if err != nil {
+		return fail(ctx, err)
+	}
+	defer file.Close()
+	return runScript(ctx, file, name)
+}
+
+//runScript runs every line of r through the same dispatcher as interactive mode. Lines ending inside quotes,
+//after a trailing backslash or after && and || continue on the next line. It returns the exit status of the
+//first failing line, stopping there when errexit is set, or zero if every line succeeded.
+func runScript(ctx context.Context, r io.Reader, name string) int {
+	ctx = logging.With(ctx, "script", name)
+	scanner := bufio.NewScanner(r)
+	firstFailure := CmdRegistry.ExitOK
+	pending := ""
+	lineNo, startLine := 0, 0
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			logging.Error(ctx, fmt.Sprintf("%s:%d: Stopping after the script was interrupted", name, lineNo))
+			return CmdRegistry.ExitCancelled
+		}
+		lineNo++
+		if pending == "" {
+			startLine = lineNo
+		}
+		text := pending + scanner.Text()
+
+		cmds, err := shell.Parse(text)
+		if err == shell.ErrUnterminated {
+			pending = text + "\n"
+			continue
+		}
+		pending = ""
+
+		status := CmdRegistry.ExitOK
+		if err != nil {
+			logging.Error(ctx, fmt.Sprintf("%s:%d: Error parsing command: %v", name, startLine, err))
+			status = CmdRegistry.ExitUsage
+		} else if len(cmds) == 0 {
+			continue
+		} else {
+			_, status = runCommands(ctx, cmds, lookupVar)
+		}
+
+		if status != CmdRegistry.ExitOK {
+			if firstFailure == CmdRegistry.ExitOK {
+				firstFailure = status
+			}
+			if errexit {
+				logging.Error(ctx, fmt.Sprintf("%s:%d: Stopping after failed command with exit status %d", name, startLine, status))
+				return status
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		logging.Error(ctx, fmt.Sprintf("%s: Error reading script: %v", name, err))
+		return CmdRegistry.ExitFailure
+	}
+	if strings.TrimSpace(pending) != "" {
+		logging.Error(ctx, fmt.Sprintf("%s:%d: Error parsing command: unexpected end of file", name, startLine))
+		return CmdRegistry.ExitUsage
+	}
+	return firstFailure
+}
+
+//processSet changes the script options, e.g. "set -e", "set +x" or "set -ex", and sets session variables given
+//as NAME=value. Without arguments it prints the options.
+func processSet(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(output.Stdout(ctx), "errexit\t%v\nxtrace\t%v\n", onOff(errexit), onOff(xtrace))
+		return CmdRegistry.ExitOK
+	}
+
+	for _, arg := range args {
+		if strings.Contains(arg, "=") && arg[0] != '-' && arg[0] != '+' {
+			if err := setVar(arg); err != nil {
+				return fail(ctx, err)
+			}
+			continue
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			return fail(ctx, CmdRegistry.Usagef("set expects options such as -e, +e, -x or +x, or variables as NAME=value"))
+		}
+		if output.FromContext(ctx).Background {
+			return fail(ctx, CmdRegistry.Usagef("set %s can't run in a background job", arg))
+		}
+		enable := arg[0] == '-'
+		for _, opt := range arg[1:] {
+			switch opt {
+			case 'e':
+				errexit = enable
+			case 'x':
+				xtrace = enable
+			default:
+				return fail(ctx, CmdRegistry.Usagef("unknown option %c", opt))
+			}
+		}
+	}
+	return CmdRegistry.ExitOK
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
@@ -1,27 +1,300 @@
 package CmdRegistry
 
 import (
+	"clitool/utils/output"
+	"context"
 	"flag"
-	"os"
+	"io/ioutil"
+	"strings"
 )
 
+//Command is a single invocation of a registered command. The registry creates a new Command through
+//Cmd.New every time the command is run so that flag values never carry over between invocations.
+//
+//The lifecycle of an invocation is Init, flag parsing, Validate, Run and finally Teardown.
+type Command interface {
+	//Init declares the command's flags, binding them to fields of the instance
+	Init(f *Flags)
+	//Run is the main entry point of the command. args are the positional arguments left after flag parsing.
+	Run(ctx context.Context, args []string) error
+}
+
+//Validator is implemented by commands that check their flags and arguments before Run is called
+type Validator interface {
+	Validate(args []string) error
+}
+
+//TearDowner is implemented by commands that need to release resources once the invocation is over.
+//Teardown is called whenever Init was called, even if parsing, validation or Run failed.
+type TearDowner interface {
+	Teardown()
+}
+
+//Cmd describes a command that can be dispatched by the CLI. Subcmds form the command tree below it,
+//e.g. "assume list". Subcommands inherit the flags of every command above them.
 type Cmd struct {
-	Name    string
-	RunCmd  func()
-	FlagSet flag.FlagSet
+	Name  string
+	Usage string
+	//New creates the instance for one invocation. parent is the instance of the command above this one
+	//in the tree, already bound to its flags, or nil for a top level command.
+	New     func(parent Command) Command
+	Subcmds []Cmd
+	//RawArgs makes the dispatcher pass the arguments to Run untouched instead of parsing them as flags,
+	//for commands that parse their own arguments such as plugins without a manifest
+	RawArgs bool
+	//Category groups top level commands in help, see Categories
+	Category string
+	//Examples are command lines shown in help, e.g. "assume -p main -n admin"
+	Examples []string
+	//Hidden keeps the command out of the help overview, completion and suggestions. It can still be run and
+	//has its own help, e.g. for commands used to maintain the CLI itself.
+	Hidden bool
+	//Mutating marks commands that change state, such as files, credentials, settings or what a remote session
+	//does, so that tools built on the schema can ask before running them
+	Mutating bool
+	//Delegates marks a command whose Run invokes another command through Invoke, such as a plugin that is only
+	//described once it runs. Hooks are called around the command it invokes instead of around it.
+	Delegates bool
 }
 
+//Categories of the top level commands, in the order help lists them
+const (
+	CategoryAWS     = "AWS"
+	CategorySearch  = "Elasticsearch"
+	CategoryConfig  = "Configuration"
+	CategoryShell   = "Shell Integration"
+	CategoryPlugins = "Plugins"
+)
+
+//Categories are the categories help lists commands under. Commands without a category are listed last.
+var Categories = []string{CategoryAWS, CategorySearch, CategoryConfig, CategoryShell, CategoryPlugins}
+
+//Path is the chain of commands from a top level command down to the one being invoked
+type Path []Cmd
+
 var Cmds = []Cmd{}
-var FlagSets = []flag.FlagSet{}
+
+//Builtins are the names of the commands handled by the dispatcher itself rather than the registry, such as help.
+//They are offered by completion next to the registered commands.
+var Builtins = []string{}
 
 func RegisterCmd(cmd Cmd) {
 	Cmds = append(Cmds, cmd)
 }
 
-func RegisterFlagSet(fs flag.FlagSet) {
-	FlagSets = append(FlagSets, fs)
+//Lookup returns the registered top level command with the given name
+func Lookup(name string) (Cmd, bool) {
+	return findCmd(Cmds, name)
+}
+
+//Sub returns the direct subcommand of c with the given name
+func (c Cmd) Sub(name string) (Cmd, bool) {
+	return findCmd(c.Subcmds, name)
+}
+
+//Names returns the names of the commands that aren't Hidden
+func Names(cmds []Cmd) []string {
+	names := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		if !c.Hidden {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+func findCmd(cmds []Cmd, name string) (Cmd, bool) {
+	for _, c := range cmds {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Cmd{}, false
+}
+
+//SensitiveFlags returns the names and aliases of the flags of every registered command that are marked Sensitive
+func SensitiveFlags() []string {
+	names := []string{}
+	var walk func(p Path)
+	walk = func(p Path) {
+		levels := p.LevelFlags()
+		for _, spec := range levels[len(levels)-1].Specs() {
+			if spec.IsSensitive {
+				names = append(names, spec.Names()...)
+			}
+		}
+		for _, sub := range p.Leaf().Subcmds {
+			walk(append(p[:len(p):len(p)], sub))
+		}
+	}
+	for _, c := range Cmds {
+		walk(Path{c})
+	}
+	return names
+}
+
+//Name returns the space separated name of the path, e.g. "assume list"
+func (p Path) Name() string {
+	names := make([]string, len(p))
+	for i, c := range p {
+		names[i] = c.Name
+	}
+	return strings.Join(names, " ")
+}
+
+//Leaf returns the command the path points to
+func (p Path) Leaf() Cmd {
+	return p[len(p)-1]
+}
+
+//Resolve routes args, the tokens following the top level command name, down the command tree.
+//It returns the path to the deepest subcommand named in args along with the remaining arguments,
+//with the subcommand names removed. Flags of a parent may be given before or after a subcommand name.
+func Resolve(name string, args []string) (Path, []string, bool) {
+	c, ok := Lookup(name)
+	if !ok {
+		return nil, nil, false
+	}
+	path, args := c.Route(args)
+	return path, args, true
+}
+
+//Route routes args down the command tree below c like Resolve does for a registered command
+func (c Cmd) Route(args []string) (Path, []string) {
+	path := Path{c}
+	for {
+		fs, _, _ := path.instances()
+		fs.SetOutput(ioutil.Discard)
+		fs.Usage = func() {}
+		if fs.Parse(args) != nil {
+			break
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		sub, ok := path.Leaf().Sub(rest[0])
+		if !ok {
+			break
+		}
+		flagArgs := args[:len(args)-len(rest)]
+		args = append(append([]string{}, flagArgs...), rest[1:]...)
+		path = append(path, sub)
+	}
+	return path, args
+}
+
+//instances creates a fresh instance of every command on the path and binds all of their flags to one FlagSet
+func (p Path) instances() (*flag.FlagSet, *Flags, []Command) {
+	fs := flag.NewFlagSet(p.Name(), flag.ContinueOnError)
+	fs.Usage = func() { PrintHelp(fs.Output(), p) }
+	flags := newFlags(fs)
+	insts := make([]Command, 0, len(p))
+	var parent Command
+	for _, c := range p {
+		flags.leaf = len(flags.specs)
+		parent = c.newInstance(parent)
+		parent.Init(flags)
+		insts = append(insts, parent)
+	}
+	flags.global()
+	return fs, flags, insts
+}
+
+func (c Cmd) newInstance(parent Command) Command {
+	if c.New == nil {
+		return groupCmd{}
+	}
+	return c.New(parent)
+}
+
+//groupCmd is the instance used for commands that only group subcommands and have no New function
+type groupCmd struct{}
+
+func (groupCmd) Init(f *Flags) {}
+
+func (groupCmd) Run(ctx context.Context, args []string) error {
+	return flag.ErrHelp
+}
+
+//Invoke runs the command the path points to on fresh instances and a fresh FlagSet, going through the whole
+//command lifecycle. Flags of the whole path are resolved from their environment bindings and checked for
+//enum values first, and the flags the leaf declares for required values. Only the leaf command is validated and run; its parents only contribute their flags.
+//A "help" argument, -h or -help prints the help of the path instead of running it. Hooks are called around Run.
+func Invoke(ctx context.Context, path Path, args []string) (err error) {
+	fs, flags, insts := path.instances()
+	defer func() {
+		for i := len(insts) - 1; i >= 0; i-- {
+			if td, ok := insts[i].(TearDowner); ok {
+				td.Teardown()
+			}
+		}
+	}()
+	defer func() {
+		if err == flag.ErrHelp {
+			fs.SetOutput(output.Stdout(ctx))
+			fs.Usage()
+		}
+	}()
+
+	if path.Leaf().RawArgs {
+		return Invocation{Path: path, Flags: map[string]string{}, Args: args}.run(ctx, insts[len(insts)-1])
+	}
+
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return flagError(err, flags)
+	}
+	if fs.Arg(0) == "help" {
+		return flag.ErrHelp
+	}
+	if err := flags.resolve(); err != nil {
+		return err
+	}
+	if len(path.Leaf().Subcmds) > 0 && fs.NArg() > 0 { //Arguments left after routing can only be a mistyped subcommand
+		return Usagef("unknown subcommand %q of %s.%s", fs.Arg(0), path.Name(), DidYouMean(fs.Arg(0), Names(path.Leaf().Subcmds), ""))
+	}
+	if flags.output != nil && flags.output.IsSet() {
+		format, err := output.ParseFormat(flags.output.Value())
+		if err != nil {
+			return &UsageError{Msg: err.Error()}
+		}
+		streams := output.FromContext(ctx)
+		streams.Format = format
+		ctx = output.WithStreams(ctx, streams)
+	}
+
+	leaf := insts[len(insts)-1]
+	if v, ok := leaf.(Validator); ok {
+		if err := v.Validate(fs.Args()); err != nil {
+			return err
+		}
+	}
+	return Invocation{Path: path, Flags: flags.values(), Args: fs.Args()}.run(ctx, leaf)
+}
+
+//flagError turns a parse failure into a UsageError, suggesting the flags closest to an undefined one
+func flagError(err error, flags *Flags) error {
+	const undefined = "flag provided but not defined: -"
+	msg := err.Error()
+	if strings.HasPrefix(msg, undefined) {
+		names := []string{}
+		for _, spec := range flags.Specs() {
+			names = append(names, spec.Names()...)
+		}
+		msg += "." + DidYouMean(strings.TrimPrefix(msg, undefined), names, "-")
+	}
+	return &UsageError{Msg: msg}
 }
 
-func CmdArgs() []string {
-	return os.Args[2:]
+//ParseFlags parses args into fs, wrapping any parse failure in a UsageError
+func ParseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || err == flag.ErrHelp {
+		return err
+	}
+	return &UsageError{Msg: err.Error()}
 }
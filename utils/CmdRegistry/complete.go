@@ -0,0 +1,177 @@
+package CmdRegistry
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//CompleteFunc returns the candidate values of a flag for completion. It is called on the instance the
+//words before the cursor were parsed into, so it can read the other flags the user has already typed.
+type CompleteFunc func(ctx context.Context) []string
+
+//Complete sets the function used to look up the candidate values of the flag, e.g. from a config file
+//or a remote service. Enum flags complete their values without one.
+func (s *FlagSpec) Complete(fn CompleteFunc) *FlagSpec {
+	s.completer = fn
+	return s
+}
+
+//Dynamic reports whether the values of the flag are looked up by a CompleteFunc rather than being a fixed enum
+func (s *FlagSpec) Dynamic() bool {
+	return s.completer != nil
+}
+
+//TakesValue reports whether the flag expects a value after its name
+func (s *FlagSpec) TakesValue() bool {
+	return !s.value.IsBoolFlag()
+}
+
+//Candidates returns the values the flag can be completed with
+func (s *FlagSpec) Candidates(ctx context.Context) []string {
+	if s.completer != nil {
+		if values := s.completer(ctx); len(values) > 0 {
+			return values
+		}
+	}
+	return s.Choices
+}
+
+//Complete returns the completion candidates for the last of words, which holds the command line typed so
+//far without the program name. The last word is the one being completed and may be empty. Depending on
+//the position it completes command names, subcommand names, flag names or flag values.
+func Complete(ctx context.Context, words []string) []string {
+	if len(words) == 0 {
+		return nil
+	}
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		return filterPrefix(append(Names(Cmds), Builtins...), cur)
+	}
+	if words[0] == "help" {
+		return completeHelp(words[1:])
+	}
+
+	path, rest, ok := Resolve(words[0], words[1:len(words)-1])
+	if !ok {
+		return nil
+	}
+	fs, flags, _ := path.instances()
+	fs.SetOutput(ioutil.Discard)
+	fs.Parse(rest) //Parse what was typed so far so completers can see it, a trailing flag without value is expected to fail
+
+	if spec, ok := valueFlag(flags, words[len(words)-2]); ok && !strings.HasPrefix(cur, "-") {
+		return filterPrefix(cachedCandidates(ctx, path, fs, spec), cur)
+	}
+	if strings.HasPrefix(cur, "-") {
+		if eq := strings.Index(cur, "="); eq > 0 {
+			name := cur[:eq+1]
+			spec, ok := flags.Lookup(strings.TrimLeft(cur[:eq], "-"))
+			if !ok {
+				return nil
+			}
+			return prefixAll(name, filterPrefix(cachedCandidates(ctx, path, fs, spec), cur[eq+1:]))
+		}
+		names := []string{}
+		for _, s := range flags.Specs() {
+			for _, name := range s.Names() {
+				names = append(names, "-"+name)
+			}
+		}
+		sort.Strings(names)
+		return filterPrefix(names, cur)
+	}
+	return filterPrefix(Names(path.Leaf().Subcmds), cur)
+}
+
+//CompletionCacheTTL is how long the values returned by a CompleteFunc are reused before it is called again.
+//Empty results are never cached.
+var CompletionCacheTTL = 5 * time.Minute
+
+//CompletionTimeout bounds how long a CompleteFunc may take so a slow lookup doesn't freeze the prompt
+var CompletionTimeout = 3 * time.Second
+
+type cacheEntry struct {
+	values  []string
+	expires time.Time
+}
+
+var completionCache = map[string]cacheEntry{}
+var completionCacheMu sync.Mutex
+
+//cachedCandidates returns the candidate values of spec, reusing earlier results of its CompleteFunc. Only
+//lookups that found values are cached. The cache key includes the flags typed so far since completers may depend on them, e.g. the clusters of an environment.
+func cachedCandidates(ctx context.Context, path Path, fs *flag.FlagSet, spec *FlagSpec) []string {
+	if spec.completer == nil {
+		return spec.Choices
+	}
+
+	key := []string{path.Name(), spec.Name}
+	fs.Visit(func(f *flag.Flag) { key = append(key, f.Name+"="+f.Value.String()) })
+	cacheKey := strings.Join(key, "\x00")
+
+	completionCacheMu.Lock()
+	entry, ok := completionCache[cacheKey]
+	completionCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.values
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, CompletionTimeout)
+	defer cancel()
+	values := spec.completer(ctx)
+	if len(values) == 0 { //A failed or timed out lookup is tried again on the next tab rather than cached
+		return spec.Choices
+	}
+
+	completionCacheMu.Lock()
+	completionCache[cacheKey] = cacheEntry{values: values, expires: time.Now().Add(CompletionCacheTTL)}
+	completionCacheMu.Unlock()
+	return values
+}
+
+//completeHelp completes the command path given to the help builtin
+func completeHelp(words []string) []string {
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		return filterPrefix(Names(Cmds), cur)
+	}
+	path, rest, ok := Resolve(words[0], words[1:len(words)-1])
+	if !ok || len(rest) > 0 {
+		return nil
+	}
+	return filterPrefix(Names(path.Leaf().Subcmds), cur)
+}
+
+//valueFlag returns the spec of word if it is a flag that expects a separate value
+func valueFlag(flags *Flags, word string) (*FlagSpec, bool) {
+	if !strings.HasPrefix(word, "-") || strings.Contains(word, "=") {
+		return nil, false
+	}
+	spec, ok := flags.Lookup(strings.TrimLeft(word, "-"))
+	if !ok || !spec.TakesValue() {
+		return nil, false
+	}
+	return spec, true
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	matches := []string{}
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			matches = append(matches, v)
+		}
+	}
+	return matches
+}
+
+func prefixAll(prefix string, values []string) []string {
+	for i, v := range values {
+		values[i] = prefix + v
+	}
+	return values
+}
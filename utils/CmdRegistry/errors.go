@@ -0,0 +1,131 @@
+package CmdRegistry
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+//Exit codes returned by the CLI when a command fails. They are stable so that scripts can tell failures apart.
+const (
+	ExitOK        = 0
+	ExitFailure   = 1   //Any other failure
+	ExitUsage     = 2   //Invalid flags or arguments, see UsageError
+	ExitAuth      = 3   //Missing, expired or insufficient credentials, see AuthError
+	ExitNotFound  = 4   //The command or a resource it works on doesn't exist, see NotFoundError
+	ExitRemote    = 5   //A remote service such as AWS or Elasticsearch failed or couldn't be reached, see RemoteError
+	ExitCancelled = 130 //Interrupted before completion, like a shell reports SIGINT
+)
+
+//UsageError is returned when a command was called with invalid flags or arguments
+type UsageError struct {
+	Msg string
+}
+
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+//Usagef creates a UsageError using the given format
+func Usagef(format string, a ...interface{}) error {
+	return &UsageError{Msg: fmt.Sprintf(format, a...)}
+}
+
+//AuthError is returned when credentials are missing, expired or not allowed to do what the command asked
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return errors.Unwrap(e.Err) }
+
+//Authf creates an AuthError using the given format. A %w verb keeps the cause reachable with errors.Is and As.
+func Authf(format string, a ...interface{}) error {
+	return &AuthError{Err: fmt.Errorf(format, a...)}
+}
+
+//NotFoundError is returned when a resource the command works on, such as a role, an instance or an index,
+//doesn't exist
+type NotFoundError struct {
+	Err error
+}
+
+func (e *NotFoundError) Error() string { return e.Err.Error() }
+func (e *NotFoundError) Unwrap() error { return errors.Unwrap(e.Err) }
+
+//NotFoundf creates a NotFoundError using the given format
+func NotFoundf(format string, a ...interface{}) error {
+	return &NotFoundError{Err: fmt.Errorf(format, a...)}
+}
+
+//RemoteError is returned when a remote service failed or couldn't be reached
+type RemoteError struct {
+	Err error
+}
+
+func (e *RemoteError) Error() string { return e.Err.Error() }
+func (e *RemoteError) Unwrap() error { return errors.Unwrap(e.Err) }
+
+//Remotef creates a RemoteError using the given format
+func Remotef(format string, a ...interface{}) error {
+	return &RemoteError{Err: fmt.Errorf(format, a...)}
+}
+
+//ExitCoder is implemented by errors that carry their own exit code, such as the *exec.ExitError of a plugin
+type ExitCoder interface {
+	ExitCode() int
+}
+
+//ExitCode maps an error returned by a command's Run function to a process exit code. A command that was
+//cancelled reports ExitCancelled whatever error it returned.
+func ExitCode(err error) int {
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	if errors.Is(err, context.Canceled) {
+		return ExitCancelled
+	}
+	var coder ExitCoder
+	if errors.As(err, &coder) && coder.ExitCode() > 0 {
+		return coder.ExitCode()
+	}
+	var (
+		usageErr    *UsageError
+		authErr     *AuthError
+		notFoundErr *NotFoundError
+		remoteErr   *RemoteError
+	)
+	switch {
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.As(err, &authErr):
+		return ExitAuth
+	case errors.As(err, &notFoundErr):
+		return ExitNotFound
+	case errors.As(err, &remoteErr), errors.Is(err, context.DeadlineExceeded):
+		return ExitRemote
+	}
+	return ExitFailure
+}
+
+//Describe returns the message shown to the user for an error returned by a command, prefixed with its kind
+func Describe(err error) string {
+	var coder ExitCoder
+	if errors.As(err, &coder) && !errors.Is(err, context.Canceled) {
+		return "Error running command: " + err.Error() //The exit code is the command's own, not one of the kinds
+	}
+	switch ExitCode(err) {
+	case ExitUsage:
+		return "Usage error: " + err.Error()
+	case ExitAuth:
+		return "Authentication error: " + err.Error()
+	case ExitNotFound:
+		return "Not found: " + err.Error()
+	case ExitRemote:
+		return "Remote error: " + err.Error()
+	case ExitCancelled:
+		return "Cancelled"
+	}
+	return "Error running command: " + err.Error()
+}
@@ -0,0 +1,400 @@
+package CmdRegistry
+
+import (
+	"clitool/utils/output"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Flags declares the flags of a command. Every flag is described by a FlagSpec built through the typed
+//declaration functions, e.g.
+//
+//	f.String(&k.env, "env", "dev", envUsage).Alias("e").Enum("dev", "sit", "prod").Env("CLITOOL_ENV")
+//
+//All names of a flag share one value and are shown as a single entry in help. The dispatcher applies
+//environment bindings and checks required and enum flags before the command is validated and run. A flag is
+//only required by the command that declares it, not by the subcommands that inherit it.
+type Flags struct {
+	fs    *flag.FlagSet
+	specs []*FlagSpec
+	//leaf is the index of the first spec declared by the command that runs, the ones before it are inherited
+	leaf int
+	//inherited is set on the flags of a parent command shown in the help of a subcommand
+	inherited bool
+	//output is the global -o flag, unless a command of the path declares a flag of that name itself
+	output *FlagSpec
+}
+
+//FlagSpec describes one flag and all of its names
+type FlagSpec struct {
+	Name       string
+	Aliases    []string
+	Usage      string
+	Type       string
+	Default    string
+	Choices    []string
+	EnvVar     string
+	IsRequired bool
+	Repeatable bool
+	//IsSensitive marks values such as tokens and passwords, which are redacted from the interactive history and logs
+	IsSensitive bool
+
+	value     flagValue
+	set       bool
+	flags     *Flags
+	completer CompleteFunc
+}
+
+//flagValue is a flag.Value that also reports whether it is a boolean flag
+type flagValue interface {
+	flag.Value
+	IsBoolFlag() bool
+}
+
+func newFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{fs: fs}
+}
+
+//String declares a string flag
+func (f *Flags) String(p *string, name string, value string, usage string) *FlagSpec {
+	*p = value
+	return f.add(name, usage, "string", value, (*stringValue)(p))
+}
+
+//Bool declares a boolean flag
+func (f *Flags) Bool(p *bool, name string, value bool, usage string) *FlagSpec {
+	*p = value
+	return f.add(name, usage, "bool", strconv.FormatBool(value), (*boolValue)(p))
+}
+
+//Int declares an integer flag
+func (f *Flags) Int(p *int, name string, value int, usage string) *FlagSpec {
+	*p = value
+	return f.add(name, usage, "int", strconv.Itoa(value), (*intValue)(p))
+}
+
+//Duration declares a flag holding a time.Duration such as "90s" or "15m"
+func (f *Flags) Duration(p *time.Duration, name string, value time.Duration, usage string) *FlagSpec {
+	*p = value
+	return f.add(name, usage, "duration", value.String(), (*durationValue)(p))
+}
+
+//Strings declares a repeatable string flag. Every occurrence appends its value to the slice.
+func (f *Flags) Strings(p *[]string, name string, usage string) *FlagSpec {
+	*p = nil
+	s := f.add(name, usage, "strings", "", (*stringsValue)(p))
+	s.Repeatable = true
+	return s
+}
+
+func (f *Flags) add(name string, usage string, typ string, def string, value flagValue) *FlagSpec {
+	s := &FlagSpec{Name: name, Usage: usage, Type: typ, Default: def, flags: f}
+	s.value = &specValue{spec: s, value: value}
+	f.fs.Var(s.value, name, usage)
+	f.specs = append(f.specs, s)
+	return s
+}
+
+//global declares the flags every command accepts after its own flags: -o, which overrides the output format
+//for this invocation only, e.g. "kssh list -o json" at the interactive prompt
+func (f *Flags) global() {
+	if _, ok := f.Lookup("o"); ok {
+		return
+	}
+	f.output = f.String(new(string), "o", "", "Output format of the results of this command, overriding the global -o: "+strings.Join(output.Formats, ", "))
+}
+
+//Specs returns the specs of every declared flag in declaration order
+func (f *Flags) Specs() []*FlagSpec {
+	return f.specs
+}
+
+//Lookup returns the spec of the flag with the given name or alias
+func (f *Flags) Lookup(name string) (*FlagSpec, bool) {
+	for _, s := range f.specs {
+		if s.Name == name {
+			return s, true
+		}
+		for _, alias := range s.Aliases {
+			if alias == name {
+				return s, true
+			}
+		}
+	}
+	return nil, false
+}
+
+//Alias adds other names for the flag, e.g. a one letter shorthand
+func (s *FlagSpec) Alias(names ...string) *FlagSpec {
+	for _, name := range names {
+		s.flags.fs.Var(s.value, name, s.Usage)
+		s.Aliases = append(s.Aliases, name)
+	}
+	return s
+}
+
+//Required makes the dispatcher refuse to run the command when the flag is set neither on the
+//command line nor through its environment variable
+func (s *FlagSpec) Required() *FlagSpec {
+	s.IsRequired = true
+	return s
+}
+
+//Redacted replaces the values of Sensitive flags in the interactive history and in logs. It has no shell meaning,
+//so a line holding it still parses.
+const Redacted = "REDACTED"
+
+//Sensitive marks the value of the flag as a secret that must not be saved to the interactive history or logged
+func (s *FlagSpec) Sensitive() *FlagSpec {
+	s.IsSensitive = true
+	return s
+}
+
+//Enum restricts the flag to the given values. Values are matched case insensitively and stored as listed.
+func (s *FlagSpec) Enum(values ...string) *FlagSpec {
+	s.Choices = values
+	return s
+}
+
+//Env binds the flag to an environment variable that is used when the flag is not given on the command line
+func (s *FlagSpec) Env(name string) *FlagSpec {
+	s.EnvVar = name
+	return s
+}
+
+//Names returns the name of the flag followed by its aliases
+func (s *FlagSpec) Names() []string {
+	return append([]string{s.Name}, s.Aliases...)
+}
+
+//IsSet reports whether the flag was given on the command line or through its environment variable
+func (s *FlagSpec) IsSet() bool {
+	return s.set
+}
+
+//Value returns the current value of the flag as a string
+func (s *FlagSpec) Value() string {
+	return s.value.String()
+}
+
+//Redact returns args, the arguments of the path as typed, with the values of its Sensitive flags replaced by
+//Redacted. The arguments of a RawArgs command are returned as is since nothing is known of them, while those of
+//a Delegates command are all redacted since its flags are only known once it runs.
+func (p Path) Redact(args []string) []string {
+	if p.Leaf().Delegates {
+		redacted := make([]string, len(args))
+		for i := range redacted {
+			redacted[i] = Redacted
+		}
+		return redacted
+	}
+	if p.Leaf().RawArgs {
+		return args
+	}
+	levels := p.LevelFlags()
+	redacted := append([]string{}, args...)
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, hasValue := strings.TrimLeft(arg, "-"), false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, hasValue = name[:eq], true
+		}
+		for _, flags := range levels {
+			s, ok := flags.Lookup(name)
+			if !ok || !s.IsSensitive {
+				continue
+			}
+			if hasValue {
+				redacted[i] = arg[:strings.Index(arg, "=")+1] + Redacted
+			} else if s.Type != "bool" && i+1 < len(redacted) {
+				i++
+				redacted[i] = Redacted
+			}
+			break
+		}
+	}
+	return redacted
+}
+
+//resolve applies environment bindings and then checks that the required flags of the leaf command were set.
+//Enum values are already checked when a value is set.
+func (f *Flags) resolve() error {
+	for i, s := range f.specs {
+		if !s.set && s.EnvVar != "" {
+			if v, ok := os.LookupEnv(s.EnvVar); ok && v != "" {
+				values := []string{v}
+				if s.Repeatable {
+					values = strings.Split(v, ",")
+				}
+				for _, value := range values {
+					if err := s.value.Set(value); err != nil {
+						return Usagef("invalid value %q for flag -%s from %s: %v", v, s.Name, s.EnvVar, err)
+					}
+				}
+			}
+		}
+		if s.IsRequired && !s.set && i >= f.leaf {
+			return Usagef("missing required flag -%s", s.Name)
+		}
+	}
+	return nil
+}
+
+//specValue wraps the typed value of a flag to record when it is set and enforce enum and repeat rules
+type specValue struct {
+	spec  *FlagSpec
+	value flagValue
+}
+
+func (v *specValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+func (v *specValue) IsBoolFlag() bool {
+	return v.value.IsBoolFlag()
+}
+
+func (v *specValue) Set(str string) error {
+	s := v.spec
+	if s.set && !s.Repeatable {
+		return fmt.Errorf("flag -%s may only be given once", s.Name)
+	}
+	if len(s.Choices) > 0 {
+		canonical, ok := matchChoice(s.Choices, str)
+		if !ok {
+			return fmt.Errorf("must be one of %s", strings.Join(s.Choices, "|"))
+		}
+		str = canonical
+	}
+	if err := v.value.Set(str); err != nil {
+		return err
+	}
+	s.set = true
+	return nil
+}
+
+func matchChoice(choices []string, str string) (string, bool) {
+	for _, c := range choices {
+		if strings.EqualFold(c, str) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+//PrintDefaults writes the help of every flag, listing all names of a flag in a single entry
+func (f *Flags) PrintDefaults(w io.Writer) {
+	specs := append([]*FlagSpec{}, f.specs...)
+	sort.SliceStable(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	for _, s := range specs {
+		fmt.Fprintln(w, "  "+s.Label())
+		usage := s.Usage
+		if details := s.Details(); len(details) > 0 {
+			usage += " (" + strings.Join(details, ", ") + ")"
+		}
+		fmt.Fprintln(w, "    \t"+usage)
+	}
+}
+
+//Label returns all names of the flag, shortest first, followed by its type unless it's a bool, e.g. "-e, -env string"
+func (s *FlagSpec) Label() string {
+	names := make([]string, 0, len(s.Aliases)+1)
+	for _, alias := range s.Aliases {
+		names = append(names, "-"+alias)
+	}
+	names = append(names, "-"+s.Name)
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) < len(names[j]) })
+
+	label := strings.Join(names, ", ")
+	if s.Type != "bool" {
+		label += " " + s.Type
+	}
+	return label
+}
+
+//Details returns what help shows after the usage of the flag: its choices, whether it is required, its default
+//unless it is Sensitive, whether it is repeatable and its environment variable
+func (s *FlagSpec) Details() []string {
+	details := []string{}
+	if len(s.Choices) > 0 {
+		details = append(details, "one of "+strings.Join(s.Choices, "|"))
+	}
+	if s.IsRequired && !s.flags.inherited {
+		details = append(details, "required")
+	} else if s.Default != "" && s.Default != "false" && s.Default != "0" && !s.IsSensitive {
+		details = append(details, fmt.Sprintf("default %q", s.Default))
+	}
+	if s.Repeatable {
+		details = append(details, "repeatable")
+	}
+	if s.EnvVar != "" {
+		details = append(details, "env "+s.EnvVar)
+	}
+	return details
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+func (v *stringValue) IsBoolFlag() bool   { return false }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v = intValue(i)
+	return nil
+}
+func (v *intValue) String() string   { return strconv.Itoa(int(*v)) }
+func (v *intValue) IsBoolFlag() bool { return false }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string   { return time.Duration(*v).String() }
+func (v *durationValue) IsBoolFlag() bool { return false }
+
+type stringsValue []string
+
+func (v *stringsValue) Set(s string) error { *v = append(*v, s); return nil }
+func (v *stringsValue) String() string     { return strings.Join(*v, ",") }
+func (v *stringsValue) IsBoolFlag() bool   { return false }
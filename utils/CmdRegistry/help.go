@@ -0,0 +1,112 @@
+package CmdRegistry
+
+import (
+	"flag"
+	"fmt"
+	"io"
+)
+
+//PrintHelp writes the help of the command the path points to: its usage, its subcommands, its own flags
+//and the flags it inherits from the commands above it
+func PrintHelp(w io.Writer, p Path) {
+	leaf := p.Leaf()
+	fmt.Fprintln(w, "Command: "+p.Name())
+	fmt.Fprintln(w, "Usage: "+leaf.Usage)
+
+	if len(leaf.Subcmds) > 0 {
+		fmt.Fprintln(w, "Subcommands")
+		for _, sub := range leaf.Subcmds {
+			fmt.Fprintf(w, "  %-10s %s\n", sub.Name, sub.Usage)
+		}
+	}
+
+	levels := p.LevelFlags()
+	if len(levels[len(levels)-1].Specs()) > 0 {
+		fmt.Fprintln(w, "Flags and Arguments")
+		levels[len(levels)-1].PrintDefaults(w)
+	}
+	for i := len(levels) - 2; i >= 0; i-- {
+		if len(levels[i].Specs()) > 0 {
+			fmt.Fprintln(w, "Flags inherited from "+p[:i+1].Name())
+			levels[i].inherited = true //Only the command declaring a flag requires it
+			levels[i].PrintDefaults(w)
+		}
+	}
+	fmt.Fprintln(w, "Global Flags")
+	GlobalFlags().PrintDefaults(w)
+
+	if len(leaf.Examples) > 0 {
+		fmt.Fprintln(w, "Examples")
+		for _, example := range leaf.Examples {
+			fmt.Fprintln(w, "  "+example)
+		}
+	}
+}
+
+//GlobalFlags returns the flags every command accepts besides its own, such as -o
+func GlobalFlags() *Flags {
+	global := newFlags(flag.NewFlagSet("global", flag.ContinueOnError))
+	global.global()
+	return global
+}
+
+//PrintCommands writes the top level commands with their usage, grouped by category
+func PrintCommands(w io.Writer) {
+	categories, byCategory := ByCategory()
+	for _, category := range categories {
+		fmt.Fprintln(w, category)
+		for _, c := range byCategory[category] {
+			fmt.Fprintf(w, "  %-10s %s\n", c.Name, c.Usage)
+		}
+	}
+}
+
+//ByCategory returns the categories that have top level commands, in the order of Categories followed by the
+//ones that aren't listed there, and the commands of each. Hidden commands are left out.
+func ByCategory() ([]string, map[string][]Cmd) {
+	categories := append([]string{}, Categories...)
+	byCategory := map[string][]Cmd{}
+	for _, c := range Cmds {
+		if c.Hidden {
+			continue
+		}
+		category := c.Category
+		if category == "" {
+			category = "Other Commands"
+		}
+		if _, ok := byCategory[category]; !ok && !contains(categories, category) {
+			categories = append(categories, category)
+		}
+		byCategory[category] = append(byCategory[category], c)
+	}
+
+	used := []string{}
+	for _, category := range categories {
+		if len(byCategory[category]) > 0 {
+			used = append(used, category)
+		}
+	}
+	return used, byCategory
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//LevelFlags returns the flags declared by each command on the path, one Flags per command
+func (p Path) LevelFlags() []*Flags {
+	levels := make([]*Flags, 0, len(p))
+	var parent Command
+	for _, c := range p {
+		flags := newFlags(flag.NewFlagSet(c.Name, flag.ContinueOnError))
+		parent = c.newInstance(parent)
+		parent.Init(flags)
+		levels = append(levels, flags)
+	}
+	return levels
+}
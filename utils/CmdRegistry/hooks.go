@@ -0,0 +1,49 @@
+package CmdRegistry
+
+import "context"
+
+//Invocation describes a command about to run, or that just ran, for hooks
+type Invocation struct {
+	Path Path
+	//Flags holds the value of every flag of the path by name, whether set or defaulted. Sensitive flags are
+	//left out so that their values never reach a hook.
+	Flags map[string]string
+	Args  []string
+}
+
+//Hooks are called around the Run of every invoked command, once its flags are parsed and it is validated.
+//An error from Before keeps the command from running and is returned in its place. After is given the error
+//returned by Run, or by Before.
+var Hooks struct {
+	Before func(ctx context.Context, inv Invocation) error
+	After  func(ctx context.Context, inv Invocation, err error)
+}
+
+//run calls the hooks around the Run of the leaf command of inv
+func (inv Invocation) run(ctx context.Context, leaf Command) (err error) {
+	//Commands that only group their subcommands print help, and the hooks of a Delegates command run around the
+	//command it invokes
+	if inv.Path.Leaf().New == nil || inv.Path.Leaf().Delegates {
+		return leaf.Run(ctx, inv.Args)
+	}
+	if Hooks.After != nil {
+		defer func() { Hooks.After(ctx, inv, err) }()
+	}
+	if Hooks.Before != nil {
+		if err := Hooks.Before(ctx, inv); err != nil {
+			return err
+		}
+	}
+	return leaf.Run(ctx, inv.Args)
+}
+
+//values returns the value of every flag that isn't sensitive by name
+func (f *Flags) values() map[string]string {
+	values := map[string]string{}
+	for _, spec := range f.specs {
+		if !spec.IsSensitive {
+			values[spec.Name] = spec.Value()
+		}
+	}
+	return values
+}
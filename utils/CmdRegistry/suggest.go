@@ -0,0 +1,92 @@
+package CmdRegistry
+
+import (
+	"sort"
+	"strings"
+)
+
+//Suggest returns up to three candidates close to a mistyped name, closest first: those within a few edits of
+//it, a third of its length at most, and those it is a prefix of
+func Suggest(name string, candidates []string) []string {
+	maxDist := len(name) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	type match struct {
+		name string
+		dist int
+	}
+	matches := []match{}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if seen[c] || c == name {
+			continue
+		}
+		seen[c] = true
+		if d := distance(name, c); d <= maxDist {
+			matches = append(matches, match{c, d})
+		} else if len(name) >= 3 && strings.HasPrefix(c, name) {
+			matches = append(matches, match{c, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	names := []string{}
+	for i := 0; i < len(matches) && i < 3; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+//DidYouMean formats the suggestions for name as a sentence to append to an error, or returns "" if there are none
+func DidYouMean(name string, candidates []string, prefix string) string {
+	suggestions := Suggest(name, candidates)
+	if len(suggestions) == 0 {
+		return ""
+	}
+	for i, s := range suggestions {
+		suggestions[i] = prefix + s
+	}
+	return " Did you mean " + strings.Join(suggestions, " or ") + "?"
+}
+
+//distance returns the Levenshtein distance between a and b, counting a swap of two adjacent letters as one edit
+func distance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,395 @@
+//Package config holds the settings of the CLI. Values are layered, each layer overriding the ones before it:
+//the defaults registered with the schema, the user file, the project file, the active context, environment
+//variables and finally -c key=value flags. Every key must be described by the schema, see Register, and the project
+//file may only set the keys that allow it, see Key.ProjectAllowed.
+//
+//A context is a named set of settings stored under "contexts.<name>", e.g. "contexts.prod.region". The settings
+//of the active context form a layer between the project file and environment variables.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Layers a value can come from, from lowest to highest precedence
+const (
+	LayerDefault = "default"
+	LayerUser    = "user"
+	LayerProject = "project"
+	LayerContext = "context"
+	LayerEnv     = "env"
+	LayerFlag    = "flag"
+)
+
+//ContextKey is the key holding the name of the active context
+const ContextKey = "context"
+
+//ContextEnv is the environment variable that selects the active context, e.g. for one terminal
+const ContextEnv = "CLITOOL_CONTEXT"
+
+//ProjectFileName is the name of the project config file, looked up in the working directory and its parents
+const ProjectFileName = ".clitool.json"
+
+//Value is the value of a key along with the layer it came from. Source is the file and line, the environment
+//variable or the flag that set it.
+type Value struct {
+	Key    string
+	Value  string
+	Layer  string
+	Source string
+}
+
+//Origin describes where the value came from, e.g. "user /home/me/.config/clitool/config.json:3"
+func (v Value) Origin() string {
+	if v.Source == "" {
+		return v.Layer
+	}
+	return v.Layer + " " + v.Source
+}
+
+func init() {
+	Register(Key{Name: ContextKey, Usage: "Name of the active context", Env: []string{ContextEnv}, ProjectAllowed: true})
+}
+
+type state struct {
+	values map[string]Value
+	errs   []error
+}
+
+var (
+	mu         sync.Mutex
+	current    *state
+	flagValues = map[string]Value{}
+	//defaultsOnly makes load stop at the defaults, see UseDefaults
+	defaultsOnly bool
+)
+
+//Dir returns the directory of the user's clitool files, $XDG_CONFIG_HOME/clitool or ~/.config/clitool
+func Dir() string {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, _ := os.UserHomeDir()
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "clitool")
+}
+
+//StateDir returns the directory of the files clitool keeps between sessions, such as the interactive history,
+//$XDG_STATE_HOME/clitool or ~/.local/state/clitool
+func StateDir() string {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, _ := os.UserHomeDir()
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "clitool")
+}
+
+//UserFile returns the path of the user config file. CLITOOL_CONFIG overrides it.
+func UserFile() string {
+	if path := os.Getenv("CLITOOL_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(Dir(), "config.json")
+}
+
+//ProjectFile returns the project config file closest to the working directory
+func ProjectFile() (string, bool) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	for {
+		path := filepath.Join(dir, ProjectFileName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+//File returns the file backing a writable layer. Without a project file, the project layer is written to the
+//working directory.
+func File(layer string) (string, error) {
+	switch layer {
+	case LayerUser:
+		return UserFile(), nil
+	case LayerProject:
+		if path, ok := ProjectFile(); ok {
+			return path, nil
+		}
+		dir, err := os.Getwd()
+		return filepath.Join(dir, ProjectFileName), err
+	}
+	return "", fmt.Errorf("the %s layer can't be written to", layer)
+}
+
+func get() *state {
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		current = load()
+	}
+	return current
+}
+
+//Reload discards the loaded values so the next read goes through every layer again
+func Reload() {
+	mu.Lock()
+	current = nil
+	mu.Unlock()
+}
+
+//UseDefaults makes reads return the registered defaults only, ignoring the files, the active context, the
+//environment and flags, e.g. so generated docs don't depend on the machine. Calling restore reads every layer again.
+func UseDefaults() (restore func()) {
+	mu.Lock()
+	defaultsOnly, current = true, nil
+	mu.Unlock()
+	return func() {
+		mu.Lock()
+		defaultsOnly, current = false, nil
+		mu.Unlock()
+	}
+}
+
+func load() *state {
+	s := &state{values: map[string]Value{}}
+	for key, value := range defaults {
+		s.values[key] = Value{Key: key, Value: value, Layer: LayerDefault}
+	}
+	if defaultsOnly {
+		return s
+	}
+
+	s.readFile(LayerUser, UserFile())
+	if path, ok := ProjectFile(); ok {
+		s.readFile(LayerProject, path)
+	}
+
+	envValues := map[string]Value{}
+	for _, k := range schema {
+		for _, env := range k.Env {
+			value := os.Getenv(env)
+			if value == "" {
+				continue
+			}
+			if err := k.check(value); err != nil {
+				s.errs = append(s.errs, fmt.Errorf("%s: %v", env, err))
+			} else {
+				envValues[k.Name] = Value{Key: k.Name, Value: value, Layer: LayerEnv, Source: env}
+			}
+			break
+		}
+	}
+
+	//The active context is picked by the highest layer that names one, so it must be known before its settings apply
+	active := s.values[ContextKey].Value
+	if v, ok := envValues[ContextKey]; ok {
+		active = v.Value
+	}
+	if v, ok := flagValues[ContextKey]; ok {
+		active = v.Value
+	}
+	if active != "" {
+		s.applyContext(active)
+	}
+
+	for key, v := range envValues {
+		s.values[key] = v
+	}
+	for key, v := range flagValues {
+		s.values[key] = v
+	}
+	s.checkChoicesFrom()
+	return s
+}
+
+//checkChoicesFrom returns an error if k has ChoicesFrom and value doesn't name a key below it
+func (k Key) checkChoicesFrom(value string) error {
+	if k.ChoicesFrom == "" {
+		return nil
+	}
+	return k.checkChoice(Children(k.ChoicesFrom), value)
+}
+
+//checkChoicesFrom ignores the values of keys with ChoicesFrom that don't name a key below it, which can only be
+//known once every layer is read. The defaults are left alone.
+func (s *state) checkChoicesFrom() {
+	for _, k := range schema {
+		v, ok := s.values[k.Name]
+		if k.ChoicesFrom == "" || !ok || v.Layer == LayerDefault {
+			continue
+		}
+		if err := k.checkChoice(children(s.values, k.ChoicesFrom), v.Value); err != nil {
+			s.errs = append(s.errs, fmt.Errorf("%s: %v", v.Source, err))
+			delete(s.values, k.Name)
+			if def, ok := defaults[k.Name]; ok {
+				s.values[k.Name] = Value{Key: k.Name, Value: def, Layer: LayerDefault}
+			}
+		}
+	}
+}
+
+//applyContext sets the keys of the settings of the named context, e.g. "region" from "contexts.prod.region"
+func (s *state) applyContext(name string) {
+	prefix := "contexts." + name + "."
+	found := false
+	for key, v := range s.values {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		found = true
+		if inner, _ := contextKey(key); inner != "" {
+			s.values[inner] = Value{Key: inner, Value: v.Value, Layer: LayerContext, Source: name}
+		}
+	}
+	if !found {
+		s.errs = append(s.errs, fmt.Errorf("the active context %s doesn't exist", name))
+	}
+}
+
+func (s *state) readFile(layer string, path string) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	values, errs := parseFile(layer, path, data)
+	s.errs = append(s.errs, errs...)
+	for key, v := range values {
+		s.values[key] = v
+	}
+}
+
+//Get returns the value of key, or an empty string if no layer sets it
+func Get(key string) string {
+	return get().values[key].Value
+}
+
+//Lookup returns the value of key along with where it came from
+func Lookup(key string) (Value, bool) {
+	v, ok := get().values[key]
+	return v, ok
+}
+
+//List returns every value that is set, sorted by key
+func List() []Value {
+	s := get()
+	values := make([]Value, 0, len(s.values))
+	for _, v := range s.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	return values
+}
+
+//Children returns the sorted names directly below prefix, e.g. the role names for "assume.roles"
+func Children(prefix string) []string {
+	return children(get().values, prefix)
+}
+
+func children(values map[string]Value, prefix string) []string {
+	seen := map[string]bool{}
+	for key := range values {
+		if rest := strings.TrimPrefix(key, prefix+"."); rest != key {
+			seen[strings.SplitN(rest, ".", 2)[0]] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Sub returns the values of the keys directly below prefix by name, e.g. the clusters of an environment
+func Sub(prefix string) map[string]string {
+	values := map[string]string{}
+	for key, v := range get().values {
+		if rest := strings.TrimPrefix(key, prefix+"."); rest != key && !strings.Contains(rest, ".") {
+			values[rest] = v.Value
+		}
+	}
+	return values
+}
+
+//Context returns the name of the active context, or an empty string if none is active
+func Context() string {
+	return Get(ContextKey)
+}
+
+//Errors returns the problems found while loading the layers, such as unknown keys or invalid values,
+//prefixed with the file and line they were found at
+func Errors() []error {
+	return get().errs
+}
+
+//SetFlag sets key for this run only, as with the -c key=value flag
+func SetFlag(key string, value string) error {
+	return SetFlagFrom("-c", key, value)
+}
+
+//SetFlagFrom sets key for this run only on behalf of a flag of its own, e.g. -o for "output"
+func SetFlagFrom(flag string, key string, value string) error {
+	k, err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	if err := k.check(value); err != nil {
+		return err
+	}
+	if err := k.checkChoicesFrom(value); err != nil {
+		return err
+	}
+	flagValues[key] = Value{Key: key, Value: value, Layer: LayerFlag, Source: flag}
+	Reload()
+	return nil
+}
+
+//Set writes key to the file of the user or project layer
+func Set(layer string, key string, value string) error {
+	k, err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	if layer == LayerProject && !k.ProjectAllowed {
+		return fmt.Errorf("%s can't be set in a project file, only in the user file", key)
+	}
+	if err := k.check(value); err != nil {
+		return err
+	}
+	if err := k.checkChoicesFrom(value); err != nil {
+		return err
+	}
+	path, err := File(layer)
+	if err != nil {
+		return err
+	}
+	defer Reload()
+	return writeFile(path, key, k.typed(value))
+}
+
+//Unset removes key from the file of the user or project layer
+func Unset(layer string, key string) error {
+	path, err := File(layer)
+	if err != nil {
+		return err
+	}
+	defer Reload()
+	return writeFile(path, key, nil)
+}
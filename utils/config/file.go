@@ -0,0 +1,304 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//fileParser reads a JSON config file into dotted keys while keeping track of the line of every key
+type fileParser struct {
+	path    string
+	layer   string
+	data    []byte
+	dec     *json.Decoder
+	counter *countingReader
+	values  map[string]Value
+	errs    []error
+}
+
+//countingReader counts the bytes the decoder has read so the position of its next token can be computed
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//parseFile parses the config file at path. Values that don't match the schema are left out and reported as
+//errors with their line. A syntax error stops parsing and drops the whole file.
+func parseFile(layer string, path string, data []byte) (map[string]Value, []error) {
+	counter := &countingReader{r: bytes.NewReader(data)}
+	p := &fileParser{path: path, layer: layer, data: data, counter: counter, values: map[string]Value{}}
+	p.dec = json.NewDecoder(counter)
+	p.dec.UseNumber()
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return p.values, nil
+	}
+	line := p.line()
+	tok, err := p.dec.Token()
+	if err == nil && tok != json.Delim('{') {
+		return nil, []error{p.errorf(line, "the config must be a JSON object")}
+	}
+	if err == nil {
+		err = p.object("")
+	}
+	if err != nil {
+		return nil, []error{p.syntaxError(err)}
+	}
+	return p.values, p.errs
+}
+
+//object reads the members of an object whose opening brace was already read
+func (p *fileParser) object(prefix string) error {
+	for p.dec.More() {
+		line := p.line()
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if err := p.value(key, line); err != nil {
+			return err
+		}
+	}
+	_, err := p.dec.Token()
+	return err
+}
+
+//value reads the value of key and stores it if the schema allows it
+func (p *fileParser) value(key string, line int) error {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		if !isPrefix(key) {
+			p.errs = append(p.errs, p.keyError(key, line, "an object"))
+			return p.skip(1)
+		}
+		return p.object(key)
+	case json.Delim('['):
+		k, ok := Find(key)
+		if !ok || k.Type != TypeList {
+			p.errs = append(p.errs, p.keyError(key, line, "a list"))
+			return p.skip(1)
+		}
+		items := []string{}
+		for p.dec.More() {
+			item, err := p.dec.Token()
+			if err != nil {
+				return err
+			}
+			s, ok := item.(string)
+			if !ok {
+				p.errs = append(p.errs, p.errorf(line, "%s must be a list of strings", key))
+				if _, isDelim := item.(json.Delim); isDelim {
+					if err := p.skip(1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			items = append(items, s)
+		}
+		if _, err := p.dec.Token(); err != nil {
+			return err
+		}
+		p.store(key, line, strings.Join(items, ","))
+		return nil
+	}
+
+	k, ok := Find(key)
+	if !ok {
+		p.errs = append(p.errs, p.keyError(key, line, "a value"))
+		return nil
+	}
+	value, err := scalar(k, tok)
+	if err != nil {
+		p.errs = append(p.errs, p.errorf(line, "%v", err))
+		return nil
+	}
+	p.store(key, line, value)
+	return nil
+}
+
+//scalar converts a JSON scalar to the string form of a value of type k.Type
+func scalar(k Key, tok json.Token) (string, error) {
+	switch v := tok.(type) {
+	case string:
+		if k.Type == TypeBool || k.Type == TypeInt {
+			return "", fmt.Errorf("%s must be a %s, got the string %q", k.Name, k.Type, v)
+		}
+		return v, k.check(v)
+	case json.Number:
+		if k.Type != TypeInt {
+			return "", fmt.Errorf("%s must be a %s, got the number %s", k.Name, k.Type, v)
+		}
+		return v.String(), k.check(v.String())
+	case bool:
+		if k.Type != TypeBool {
+			return "", fmt.Errorf("%s must be a %s, got %v", k.Name, k.Type, v)
+		}
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("%s must be a %s, got null", k.Name, k.Type)
+}
+
+func (p *fileParser) store(key string, line int, value string) {
+	if k, _ := Find(key); p.layer == LayerProject && !k.ProjectAllowed {
+		p.errs = append(p.errs, p.errorf(line, "%s can't be set in a project file, only in the user file", key))
+		return
+	}
+	p.values[key] = Value{Key: key, Value: value, Layer: p.layer, Source: fmt.Sprintf("%s:%d", p.path, line)}
+}
+
+//skip reads tokens until depth nested objects and arrays are closed
+func (p *fileParser) skip(depth int) error {
+	for depth > 0 {
+		tok, err := p.dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+//offset returns the position in the file of the next token the decoder will return
+func (p *fileParser) offset() int64 {
+	buffered, _ := io.Copy(ioutil.Discard, p.dec.Buffered())
+	off := p.counter.n - buffered
+	for off < int64(len(p.data)) && strings.ContainsRune(" \t\r\n,:", rune(p.data[off])) {
+		off++
+	}
+	return off
+}
+
+func (p *fileParser) line() int {
+	return lineAt(p.data, p.offset())
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func (p *fileParser) errorf(line int, format string, a ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.path, line, fmt.Sprintf(format, a...))
+}
+
+func (p *fileParser) keyError(key string, line int, got string) error {
+	if k, ok := Find(key); ok {
+		return p.errorf(line, "%s must be a %s, got %s", key, k.Type, got)
+	}
+	if isPrefix(key) {
+		return p.errorf(line, "%s must be an object, got %s", key, got)
+	}
+	return p.errorf(line, "unknown key %s", key)
+}
+
+func (p *fileParser) syntaxError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return p.errorf(lineAt(p.data, syntaxErr.Offset), "%v", err)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return p.errorf(lineAt(p.data, int64(len(p.data))), "unexpected end of file")
+	}
+	return p.errorf(p.line(), "%v", err)
+}
+
+//writeFile sets key to value in the config file at path, creating the file and the objects leading to the key
+//as needed. A nil value removes the key.
+func writeFile(path string, key string, value interface{}) error {
+	root := map[string]interface{}{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&root); err != nil {
+			return fmt.Errorf("error reading %s: %w", path, err)
+		}
+	}
+
+	segments := strings.Split(key, ".")
+	obj := root
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := obj[segment]
+		if !ok {
+			if value == nil {
+				return nil
+			}
+			child = map[string]interface{}{}
+			obj[segment] = child
+		}
+		childObj, ok := child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s in %s is not an object", segment, path)
+		}
+		obj = childObj
+	}
+	if value == nil {
+		delete(obj, segments[len(segments)-1])
+	} else {
+		obj[segments[len(segments)-1]] = value
+	}
+
+	data, err = json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+//typed converts a string value to the JSON value written to a file for type k.Type
+func (k Key) typed(value string) interface{} {
+	switch k.Type {
+	case TypeBool:
+		b, _ := strconv.ParseBool(value)
+		return b
+	case TypeInt:
+		return json.Number(value)
+	case TypeList:
+		if value == "" {
+			return []string{}
+		}
+		return strings.Split(value, ",")
+	case TypeDuration:
+		d, _ := time.ParseDuration(value)
+		return d.String()
+	}
+	return value
+}
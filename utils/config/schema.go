@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Key describes a setting that can appear in the configuration. Name is a dotted path in which a * segment
+//matches any single name, e.g. "assume.roles.*" for the role ARN of every role name.
+type Key struct {
+	Name  string
+	Type  string
+	Usage string
+	//Default is the value of the key when no layer sets it. It is only used for names without a * segment.
+	Default string
+	//Env lists the environment variables that set the key, the first one set wins
+	Env []string
+	//Choices restricts the key to the listed values
+	Choices []string
+	//ChoicesFrom restricts the key to the names directly below another key, e.g. "elastic.clusters" for the
+	//environments that have clusters
+	ChoicesFrom string
+	//ProjectAllowed lets the project file set the key. A project file comes with whatever directory the CLI runs
+	//in, so keys that run commands, write files or change what the prompt warns about, such as hooks, aliases and
+	//log.file, are only read from the user file, the environment and flags.
+	ProjectAllowed bool
+}
+
+//Setting types
+const (
+	TypeString   = "string"
+	TypeBool     = "bool"
+	TypeInt      = "int"
+	TypeDuration = "duration"
+	//TypeList is a list of strings, written as a JSON array and read as a comma separated value
+	TypeList = "list"
+)
+
+var schema = []Key{}
+var defaults = map[string]string{}
+
+//Register adds a key to the schema. Packages register the keys they read in their init function.
+func Register(k Key) {
+	if k.Type == "" {
+		k.Type = TypeString
+	}
+	schema = append(schema, k)
+	if k.Default != "" && !strings.Contains(k.Name, "*") {
+		defaults[k.Name] = k.Default
+	}
+}
+
+//SetDefault sets the default value of a key matching a pattern of the schema, e.g. a default role for "assume.roles.*"
+func SetDefault(key string, value string) {
+	defaults[key] = value
+}
+
+//Schema returns the registered keys in registration order
+func Schema() []Key {
+	return schema
+}
+
+//Find returns the schema key matching the given key. The settings of a context, e.g. "contexts.prod.region",
+//match the key they set.
+func Find(key string) (Key, bool) {
+	if inner, ok := contextKey(key); ok {
+		if inner == "" {
+			return Key{}, false
+		}
+		return Find(inner)
+	}
+	for _, k := range schema {
+		if match(k.Name, key) {
+			return k, true
+		}
+	}
+	return Key{}, false
+}
+
+//match reports whether key matches pattern segment by segment
+func match(pattern string, key string) bool {
+	p, k := strings.Split(pattern, "."), strings.Split(key, ".")
+	if len(p) != len(k) {
+		return false
+	}
+	for i := range p {
+		if k[i] == "" || (p[i] != "*" && p[i] != k[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//isPrefix reports whether some key of the schema is nested below key, e.g. "profiles.main" for "profiles.*.role_arn"
+func isPrefix(key string) bool {
+	if inner, ok := contextKey(key); ok {
+		return inner == "" || isPrefix(inner)
+	}
+	if key == "contexts" {
+		return true
+	}
+	segments := strings.Split(key, ".")
+	for _, k := range schema {
+		p := strings.Split(k.Name, ".")
+		if len(p) > len(segments) && match(strings.Join(p[:len(segments)], "."), key) {
+			return true
+		}
+	}
+	return false
+}
+
+//contextKey returns the key set by a setting of a context, e.g. "region" for "contexts.prod.region". The key is
+//empty for the context itself, "contexts.prod". Contexts can't set the active context or other contexts.
+func contextKey(key string) (string, bool) {
+	segments := strings.SplitN(key, ".", 3)
+	if segments[0] != "contexts" || len(segments) < 2 || segments[1] == "" {
+		return "", false
+	}
+	if len(segments) == 2 {
+		return "", true
+	}
+	inner := segments[2]
+	if inner == ContextKey || inner == "contexts" || strings.HasPrefix(inner, "contexts.") {
+		return "", true
+	}
+	return inner, true
+}
+
+//check validates a value given as a string, e.g. on the command line, against the type of its key
+func (k Key) check(value string) error {
+	var err error
+	switch k.Type {
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeInt:
+		_, err = strconv.Atoi(value)
+	case TypeDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be a %s, got %q", k.Name, k.Type, value)
+	}
+	return k.checkChoice(k.Choices, value)
+}
+
+//checkChoice returns an error if choices are given and value isn't one of them
+func (k Key) checkChoice(choices []string, value string) error {
+	if len(choices) == 0 {
+		return nil
+	}
+	for _, choice := range choices {
+		if choice == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s, got %q", k.Name, strings.Join(choices, "|"), value)
+}
+
+//checkKey returns an error if key isn't described by the schema
+func checkKey(key string) (Key, error) {
+	k, ok := Find(key)
+	if !ok {
+		return Key{}, fmt.Errorf("unknown key %s", key)
+	}
+	return k, nil
+}
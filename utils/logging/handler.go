@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"bytes"
+	"clitool/utils/output"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//consoleHandler writes messages for humans to the stderr of the context. Info messages are written as is and
+//the fields of the context, such as the command, are only shown at debug level.
+type consoleHandler struct {
+	level Level
+}
+
+func (h *consoleHandler) Enabled(level Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, r Record) error {
+	var b bytes.Buffer
+	switch r.Level {
+	case LevelDebug:
+		b.WriteString("DEBUG ")
+	case LevelWarn:
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(r.Message)
+
+	fields := r.Fields
+	if h.level == LevelDebug {
+		fields = append(r.Context[:len(r.Context):len(r.Context)], r.Fields...)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%s", f.Key, consoleValue(f.Value))
+	}
+	b.WriteByte('\n')
+	_, err := output.Stderr(ctx).Write(b.Bytes())
+	return err
+}
+
+//consoleValue formats a field value, quoting it when it has spaces. Multi line values, such as stack traces,
+//start on a line of their own.
+func consoleValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if err, ok := v.(error); ok {
+		s = err.Error()
+	}
+	if strings.Contains(s, "\n") {
+		return "\n" + strings.TrimRight(s, "\n")
+	}
+	if s == "" || strings.ContainsAny(s, " \t\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//jsonHandler writes each message as a JSON object on its own line, with the time, level and message followed
+//by the fields of the context and of the message
+type jsonHandler struct {
+	level Level
+	w     io.Writer
+}
+
+func (h *jsonHandler) Enabled(level Level) bool {
+	return level >= h.level
+}
+
+func (h *jsonHandler) Handle(ctx context.Context, r Record) error {
+	var b bytes.Buffer
+	b.WriteString("{")
+	writeField(&b, "time", r.Time.Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeField(&b, "level", r.Level.String())
+	b.WriteString(",")
+	writeField(&b, "msg", r.Message)
+	for _, fields := range [][]Field{r.Context, r.Fields} {
+		for _, f := range fields {
+			b.WriteString(",")
+			writeField(&b, f.Key, f.Value)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := h.w.Write(b.Bytes())
+	return err
+}
+
+//writeField writes "key":value, falling back to the string form of values that can't be marshalled
+func writeField(b *bytes.Buffer, key string, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	name, _ := json.Marshal(key)
+	fmt.Fprintf(b, "%s:%s", name, data)
+}
@@ -0,0 +1,199 @@
+//Package logging is the leveled, structured logger shared by the commands. Messages go to stderr through a
+//console handler meant for humans and, when a log file is set, to a handler writing one JSON object per line.
+//
+//Fields are given as key and value pairs, e.g. logging.Info(ctx, "Querying cluster", "cluster", name). Fields
+//attached to the context with With, such as the command being run, are added to every message logged with it.
+package logging
+
+import (
+	"clitool/utils/config"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Level is the severity of a message
+type Level int
+
+//Levels, from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+//ParseLevel parses the name of a level, e.g. "debug"
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, use one of %s", s, strings.Join(levelNames, ", "))
+}
+
+//Field is a key and value attached to a message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+//Record is a logged message
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	//Context holds the fields attached to the context, Fields those given with the message
+	Context []Field
+	Fields  []Field
+}
+
+//Handler writes the records at or above its level somewhere
+type Handler interface {
+	Enabled(level Level) bool
+	Handle(ctx context.Context, r Record) error
+}
+
+func init() {
+	config.Register(config.Key{Name: "log.level", Usage: "Level of the messages written to stderr, one of " + strings.Join(levelNames, ", "), Default: "info", Env: []string{"CLITOOL_LOG_LEVEL"}, ProjectAllowed: true})
+	config.Register(config.Key{Name: "log.file", Usage: "File that messages are appended to as JSON lines", Env: []string{"CLITOOL_LOG_FILE"}})
+}
+
+var (
+	mu       sync.Mutex
+	handlers = []Handler{&consoleHandler{level: LevelInfo}}
+	logFile  *os.File
+)
+
+//Configure sets up the handlers from the log.level and log.file settings. The log file receives the messages
+//at the level of the console and at least the info ones.
+func Configure() error {
+	level, err := ParseLevel(config.Get("log.level"))
+	if err != nil {
+		return err
+	}
+	configured := []Handler{&consoleHandler{level: level}}
+
+	var file *os.File
+	if path := config.Get("log.file"); path != "" {
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening the log file: %w", err)
+		}
+		fileLevel := level
+		if fileLevel > LevelInfo {
+			fileLevel = LevelInfo
+		}
+		configured = append(configured, &jsonHandler{level: fileLevel, w: file})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if logFile != nil {
+		logFile.Close()
+	}
+	handlers, logFile = configured, file
+	return nil
+}
+
+//Close closes the log file, if any
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if logFile == nil {
+		return nil
+	}
+	err := logFile.Close()
+	handlers, logFile = handlers[:1], nil
+	return err
+}
+
+type fieldsKey struct{}
+
+//With returns a context whose messages carry the given key and value pairs
+func With(ctx context.Context, kv ...interface{}) context.Context {
+	fields := append(contextFields(ctx), pairs(kv)...)
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func contextFields(ctx context.Context) []Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]Field)
+	return fields[:len(fields):len(fields)] //Appending must not write into the parent's slice
+}
+
+//pairs turns key and value pairs into fields. A key without a value gets an empty one.
+func pairs(kv []interface{}) []Field {
+	fields := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		field := Field{Key: fmt.Sprint(kv[i])}
+		if i+1 < len(kv) {
+			field.Value = kv[i+1]
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+//Enabled reports whether messages at level are written anywhere, so that costly fields can be skipped
+func Enabled(level Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, h := range handlers {
+		if h.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+//Log writes a message at the given level with the fields of the context and the key and value pairs kv
+func Log(ctx context.Context, level Level, msg string, kv ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var r *Record
+	for _, h := range handlers {
+		if !h.Enabled(level) {
+			continue
+		}
+		if r == nil {
+			r = &Record{Time: time.Now(), Level: level, Message: msg, Context: contextFields(ctx), Fields: pairs(kv)}
+		}
+		if err := h.Handle(ctx, *r); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing log:", err)
+		}
+	}
+}
+
+//Debug logs details that help troubleshooting, shown with -v
+func Debug(ctx context.Context, msg string, kv ...interface{}) {
+	Log(ctx, LevelDebug, msg, kv...)
+}
+
+//Info logs the progress of a command, hidden with -q
+func Info(ctx context.Context, msg string, kv ...interface{}) {
+	Log(ctx, LevelInfo, msg, kv...)
+}
+
+//Warn logs a problem that doesn't stop the command
+func Warn(ctx context.Context, msg string, kv ...interface{}) {
+	Log(ctx, LevelWarn, msg, kv...)
+}
+
+//Error logs a failure
+func Error(ctx context.Context, msg string, kv ...interface{}) {
+	Log(ctx, LevelError, msg, kv...)
+}
@@ -0,0 +1,230 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//node is a value decoded from JSON that keeps the order of object keys: nil, bool, json.Number, string,
+//[]node or *object
+type node interface{}
+
+//object is a JSON object whose keys are kept in the order of the struct fields they came from
+type object struct {
+	keys   []string
+	values map[string]node
+}
+
+//toNode converts v to its JSON form, keeping the field order of structs
+func toNode(v interface{}) (node, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeNode(dec)
+}
+
+//Field returns the value at path in the JSON form of v, formatted like a table cell, e.g. "id" or "2.id".
+//Numbers index lists, 0 being the first element, and a name applied to a list refers to its first element.
+//An empty path returns the first field of the first element.
+func Field(v interface{}, path string) (string, bool) {
+	n, err := toNode(v)
+	if err != nil {
+		return "", false
+	}
+	if path == "" {
+		if list, ok := n.([]node); ok && len(list) > 0 {
+			n = list[0]
+		}
+		if obj, ok := n.(*object); ok && len(obj.keys) > 0 {
+			n = obj.values[obj.keys[0]]
+		}
+		return cell(n), n != nil
+	}
+
+	for _, name := range strings.Split(path, ".") {
+		if list, ok := n.([]node); ok {
+			i, err := strconv.Atoi(name)
+			if err == nil && i >= 0 && i < len(list) {
+				n = list[i]
+				continue
+			} else if err == nil || len(list) == 0 {
+				return "", false
+			}
+			n = list[0]
+		}
+		obj, ok := n.(*object)
+		if !ok {
+			return "", false
+		}
+		if n, ok = obj.values[name]; !ok {
+			return "", false
+		}
+	}
+	return cell(n), true
+}
+
+func decodeNode(dec *json.Decoder) (node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &object{values: map[string]node{}}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			value, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, key)
+			obj.values[key] = value
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []node{}
+		for dec.More() {
+			item, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+func writeJSON(w io.Writer, n node) {
+	switch v := n.(type) {
+	case *object:
+		io.WriteString(w, "{")
+		for i, key := range v.keys {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s:", strconv.Quote(key))
+			writeJSON(w, v.values[key])
+		}
+		io.WriteString(w, "}")
+	case []node:
+		io.WriteString(w, "[")
+		for i, item := range v {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			writeJSON(w, item)
+		}
+		io.WriteString(w, "]")
+	default:
+		data, _ := json.Marshal(v)
+		w.Write(data)
+	}
+}
+
+//writeYAML writes n as a YAML document
+func writeYAML(w io.Writer, n node) error {
+	var b bytes.Buffer
+	switch v := n.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			b.WriteString("{}\n")
+		}
+		yamlObject(&b, v, 0)
+	case []node:
+		if len(v) == 0 {
+			b.WriteString("[]\n")
+		}
+		yamlList(&b, v, 0)
+	default:
+		b.WriteString(yamlScalar(n) + "\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func yamlObject(b *bytes.Buffer, obj *object, indent int) {
+	pad := strings.Repeat("  ", indent)
+	for _, key := range obj.keys {
+		b.WriteString(pad + yamlString(key) + ":")
+		yamlValue(b, obj.values[key], indent)
+	}
+}
+
+func yamlList(b *bytes.Buffer, list []node, indent int) {
+	pad := strings.Repeat("  ", indent)
+	for _, item := range list {
+		b.WriteString(pad + "-")
+		if obj, ok := item.(*object); ok && len(obj.keys) > 0 {
+			//The first key goes on the line of the dash, the others line up with it
+			var nested bytes.Buffer
+			yamlObject(&nested, obj, indent+1)
+			b.WriteString(" " + strings.TrimPrefix(nested.String(), pad+"  "))
+			continue
+		}
+		yamlValue(b, item, indent)
+	}
+}
+
+//yamlValue writes the value following a key or a dash, nesting collections on the next lines
+func yamlValue(b *bytes.Buffer, n node, indent int) {
+	switch v := n.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		yamlObject(b, v, indent+1)
+	case []node:
+		if len(v) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		yamlList(b, v, indent+1)
+	default:
+		b.WriteString(" " + yamlScalar(n) + "\n")
+	}
+}
+
+func yamlScalar(n node) string {
+	switch v := n.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	}
+	return fmt.Sprint(n)
+}
+
+//yamlString quotes s when it would otherwise be read as another type or break the syntax
+func yamlString(s string) string {
+	if s == "" {
+		return `""`
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~", "y", "n":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	if strings.ContainsAny(s, ":#{}[],&*!|>'\"%@`\n\t\\") || strings.TrimSpace(s) != s || strings.HasPrefix(s, "-") || strings.HasPrefix(s, "?") {
+		return strconv.Quote(s)
+	}
+	return s
+}
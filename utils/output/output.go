@@ -0,0 +1,124 @@
+//Package output renders the results of commands in the format picked with the global -o flag. Commands pass
+//their results to Emit as structs, or slices of structs, with json tags, and log progress and diagnostics
+//with the logging package, which writes them to Stderr, so that stdout only holds the result.
+package output
+
+import (
+	"clitool/utils/config"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//Output formats
+const (
+	Table    = "table"
+	JSON     = "json"
+	YAML     = "yaml"
+	TSV      = "tsv"
+	Template = "template"
+)
+
+//Formats are the names accepted by the -o flag. A template is given as template=<Go template>.
+var Formats = []string{Table, JSON, YAML, TSV, Template + "=..."}
+
+//Format is a parsed output format
+type Format struct {
+	Name string
+	//Template is the text of the Go template of the template format
+	Template string
+}
+
+func init() {
+	config.Register(config.Key{Name: "output", Usage: "Output format of command results, one of " + strings.Join(Formats, ", "), Default: Table, Env: []string{"CLITOOL_OUTPUT"}, ProjectAllowed: true})
+}
+
+//String returns the format as given to the -o flag
+func (f Format) String() string {
+	if f.Name == Template {
+		return Template + "=" + f.Template
+	}
+	return f.Name
+}
+
+//Configured returns the format of the output setting, set with the -o flag, CLITOOL_OUTPUT or the config files
+func Configured() (Format, error) {
+	return ParseFormat(config.Get("output"))
+}
+
+//ParseFormat parses the value of the -o flag, e.g. "json" or "template={{.name}}"
+func ParseFormat(s string) (Format, error) {
+	if strings.HasPrefix(s, Template+"=") {
+		return Format{Name: Template, Template: strings.TrimPrefix(s, Template+"=")}, nil
+	}
+	switch s {
+	case Table, JSON, YAML, TSV:
+		return Format{Name: s}, nil
+	case Template:
+		return Format{}, fmt.Errorf("the template format needs a template, e.g. template={{.name}}")
+	}
+	return Format{}, fmt.Errorf("unknown output format %q, use one of %s", s, strings.Join(Formats, ", "))
+}
+
+//Streams are the reader and writers of a command invocation
+type Streams struct {
+	In     io.Reader
+	Out    io.Writer
+	Err    io.Writer
+	Format Format
+	//Background is set for background jobs, which have no terminal to read from or to be interrupted by
+	Background bool
+	//Emitted, if set, is called with every result passed to Emit, e.g. to keep the last result of the REPL
+	Emitted func(v interface{})
+}
+
+type streamsKey struct{}
+
+//WithStreams returns a context whose commands output to s
+func WithStreams(ctx context.Context, s Streams) context.Context {
+	return context.WithValue(ctx, streamsKey{}, s)
+}
+
+//FromContext returns the streams of the context, defaulting to os.Stdin, os.Stdout, os.Stderr and the table format
+func FromContext(ctx context.Context) Streams {
+	s, _ := ctx.Value(streamsKey{}).(Streams)
+	if s.In == nil {
+		s.In = os.Stdin
+	}
+	if s.Out == nil {
+		s.Out = os.Stdout
+	}
+	if s.Err == nil {
+		s.Err = os.Stderr
+	}
+	if s.Format.Name == "" {
+		s.Format.Name = Table
+	}
+	return s
+}
+
+//Stdin returns the reader commands read input from, such as the answer to a prompt
+func Stdin(ctx context.Context) io.Reader {
+	return FromContext(ctx).In
+}
+
+//Stdout returns the writer results are written to
+func Stdout(ctx context.Context) io.Writer {
+	return FromContext(ctx).Out
+}
+
+//Stderr returns the writer progress messages and diagnostics are written to
+func Stderr(ctx context.Context) io.Writer {
+	return FromContext(ctx).Err
+}
+
+//Emit renders the result of a command to Stdout in the format of the context
+func Emit(ctx context.Context, v interface{}) error {
+	s := FromContext(ctx)
+	if s.Emitted != nil {
+		s.Emitted(v)
+	}
+	return Render(s.Out, s.Format, v)
+}
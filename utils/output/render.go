@@ -0,0 +1,161 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+//Render writes v to w in the given format. Table and TSV output have one row per element of a slice and one
+//column per field; nested values are written as compact JSON. The template is executed once per element.
+func Render(w io.Writer, f Format, v interface{}) error {
+	if f.Name == JSON {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+
+	doc, err := toNode(v)
+	if err != nil {
+		return err
+	}
+	switch f.Name {
+	case YAML:
+		return writeYAML(w, doc)
+	case Template:
+		return renderTemplate(w, f.Template, v)
+	case TSV:
+		return renderRows(w, doc, false)
+	}
+	return renderRows(w, doc, true)
+}
+
+//renderRows writes the rows of doc separated by tabs. Tables are aligned and start with a header.
+func renderRows(w io.Writer, doc node, header bool) error {
+	items := []node{doc}
+	if list, ok := doc.([]node); ok {
+		items = list
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if obj, ok := item.(*object); ok {
+			for _, key := range obj.keys {
+				if !seen[key] {
+					seen[key] = true
+					columns = append(columns, key)
+				}
+			}
+		}
+	}
+
+	out := w
+	var tw *tabwriter.Writer
+	if header {
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		out = tw
+		if len(columns) > 0 {
+			titles := make([]string, len(columns))
+			for i, c := range columns {
+				titles[i] = strings.ToUpper(strings.Replace(c, "_", " ", -1))
+			}
+			fmt.Fprintln(out, strings.Join(titles, "\t"))
+		}
+	}
+
+	for _, item := range items {
+		obj, ok := item.(*object)
+		if !ok || len(columns) == 0 {
+			fmt.Fprintln(out, cell(item))
+			continue
+		}
+		cells := make([]string, len(columns))
+		for i, c := range columns {
+			cells[i] = cell(obj.values[c])
+		}
+		fmt.Fprintln(out, strings.Join(cells, "\t"))
+	}
+
+	if tw != nil {
+		return tw.Flush()
+	}
+	return nil
+}
+
+//cell formats one value of a row. Lists of scalars are joined with commas.
+func cell(n node) string {
+	switch v := n.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.Replace(strings.Replace(v, "\t", " ", -1), "\n", " ", -1)
+	case []node:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item.(type) {
+			case *object, []node:
+				return compact(n)
+			}
+			parts = append(parts, cell(item))
+		}
+		return strings.Join(parts, ",")
+	case *object:
+		return compact(n)
+	}
+	return fmt.Sprint(n)
+}
+
+func compact(n node) string {
+	var b bytes.Buffer
+	writeJSON(&b, n)
+	return b.String()
+}
+
+//renderTemplate executes the template against the JSON form of v, so fields are referred to by their json
+//names, once per element when v is a slice
+func renderTemplate(w io.Writer, text string, v interface{}) error {
+	tmpl, err := template.New("output").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid output template: %w", err)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+
+	items := []interface{}{generic}
+	if list, ok := generic.([]interface{}); ok {
+		items = list
+	}
+	for _, item := range items {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, item); err != nil {
+			return fmt.Errorf("error executing output template: %w", err)
+		}
+		if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//manifestCache keeps the manifests of plugins between runs so they aren't executed on every start.
+//An entry is reused as long as the executable keeps the same size and modification time.
+type manifestCache struct {
+	path    string
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+type cacheEntry struct {
+	ModTime  time.Time
+	Size     int64
+	Manifest Manifest
+	Raw      bool
+}
+
+//loadCache reads the cache from the user's cache directory. A missing or unreadable cache starts empty.
+func loadCache() *manifestCache {
+	cache := &manifestCache{entries: map[string]cacheEntry{}}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return cache
+	}
+	cache.path = filepath.Join(dir, "clitool", "plugins.json")
+	if data, err := ioutil.ReadFile(cache.path); err == nil {
+		json.Unmarshal(data, &cache.entries)
+	}
+	return cache
+}
+
+func (c *manifestCache) get(path string, info os.FileInfo) (cacheEntry, bool) {
+	entry, ok := c.entries[path]
+	if !ok || !entry.ModTime.Equal(info.ModTime()) || entry.Size != info.Size() {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *manifestCache) put(path string, info os.FileInfo, entry cacheEntry) {
+	entry.ModTime, entry.Size = info.ModTime(), info.Size()
+	c.entries[path] = entry
+	c.dirty = true
+}
+
+//save writes the cache back if a manifest was added. Failing to write it only costs a slower start next time.
+func (c *manifestCache) save() {
+	if !c.dirty || c.path == "" {
+		return
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(c.path), 0700) == nil {
+		ioutil.WriteFile(c.path, data, 0600)
+	}
+}
@@ -0,0 +1,338 @@
+//Package plugin discovers external clitool-<name> executables and registers them as commands.
+//
+//A plugin describes itself by printing a JSON manifest when it is called with --clitool-manifest, e.g.
+//
+//	{
+//	  "usage": "Deploys an application",
+//	  "flags": [{"name": "app", "aliases": ["a"], "usage": "Application to deploy", "required": true}],
+//	  "subcommands": [{"name": "status", "usage": "Prints the deployment status"}]
+//	}
+//
+//Flags are declared with the same options as the flags of built in commands, so the dispatcher checks
+//them and help and completion show them. They are passed to the plugin as --name=value, followed by the
+//subcommand names and the positional arguments. A plugin that prints no manifest receives its arguments untouched.
+package plugin
+
+import (
+	"bytes"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Prefix is the prefix of the executables discovered as plugins, e.g. clitool-deploy provides the deploy command
+const Prefix = "clitool-"
+
+//ManifestFlag is passed to a plugin to ask it for its manifest instead of running it
+const ManifestFlag = "--clitool-manifest"
+
+//ManifestTimeout bounds how long a plugin may take to print its manifest
+var ManifestTimeout = 5 * time.Second
+
+//InterruptGracePeriod is how long a plugin may take to exit after the command was cancelled before it is killed
+var InterruptGracePeriod = 3 * time.Second
+
+//Manifest describes the usage, flags and subcommands of a plugin
+type Manifest struct {
+	Usage       string       `json:"usage"`
+	Flags       []Flag       `json:"flags,omitempty"`
+	Subcommands []Subcommand `json:"subcommands,omitempty"`
+	Examples    []string     `json:"examples,omitempty"`
+	//Mutating tells that the command changes state, see CmdRegistry.Cmd.Mutating
+	Mutating bool `json:"mutating,omitempty"`
+}
+
+//Subcommand is a named Manifest below the plugin command
+type Subcommand struct {
+	Name string `json:"name"`
+	Manifest
+}
+
+//Flag describes one flag of a plugin. Type is one of string, bool, int, duration or strings, the
+//repeatable string flag, and defaults to string.
+type Flag struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Default  string   `json:"default,omitempty"`
+	Usage    string   `json:"usage,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Env      string   `json:"env,omitempty"`
+	//Sensitive keeps the value of the flag out of the interactive history, e.g. for tokens
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+//Plugin is an executable discovered on disk
+type Plugin struct {
+	Name     string
+	Path     string
+	Manifest Manifest
+	//Raw is set when the plugin printed no manifest. Its arguments are then passed through untouched.
+	Raw bool
+}
+
+//Dirs returns the directories searched for plugins in order of precedence: CLITOOL_PLUGIN_DIR or the
+//plugins directory next to the user config file, then every directory of PATH
+func Dirs() []string {
+	dirs := []string{}
+	if dir := os.Getenv("CLITOOL_PLUGIN_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	} else {
+		dirs = append(dirs, filepath.Join(config.Dir(), "plugins"))
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+//Discover returns the plugins found in dirs. When two directories hold a plugin of the same name, the first one wins.
+func Discover(dirs []string) []Plugin {
+	seen := map[string]bool{}
+	plugins := []Plugin{}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(entry.Name())
+			if !ok || seen[name] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			if !isExecutable(path) {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, Plugin{Name: name, Path: path})
+		}
+	}
+	return plugins
+}
+
+//pluginName returns the command name provided by the executable with the given file name
+func pluginName(file string) (string, bool) {
+	if !strings.HasPrefix(file, Prefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(file, Prefix)
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext != ".exe" && ext != ".bat" && ext != ".cmd" {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name, name != ""
+}
+
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || info.Mode()&0111 != 0
+}
+
+//cached sets the manifest of the plugin from the cache, if it holds one for the executable as it is now
+func (p *Plugin) cached(cache *manifestCache) bool {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return false
+	}
+	entry, ok := cache.get(p.Path, info)
+	if ok {
+		p.Manifest, p.Raw = entry.Manifest, entry.Raw
+	}
+	return ok
+}
+
+//load asks the plugin for its manifest, reusing the cached one while the executable is unchanged. A plugin that
+//fails or prints nothing is marked Raw. A manifest that isn't valid is an error.
+func (p *Plugin) load(ctx context.Context, cache *manifestCache) error {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return err
+	}
+	if p.cached(cache) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ManifestTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, ManifestFlag)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil || len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		p.Manifest, p.Raw = Manifest{Usage: "External plugin " + p.Path}, true
+	} else {
+		if err := json.Unmarshal(stdout.Bytes(), &p.Manifest); err != nil {
+			return fmt.Errorf("invalid manifest: %w", err)
+		}
+		if err := p.Manifest.validate(); err != nil {
+			return fmt.Errorf("invalid manifest: %w", err)
+		}
+	}
+
+	cache.put(p.Path, info, cacheEntry{Manifest: p.Manifest, Raw: p.Raw})
+	return nil
+}
+
+//validate checks that every flag has a name, a known type and a default that parses as that type
+func (m Manifest) validate() error {
+	for _, f := range m.Flags {
+		if f.Name == "" {
+			return fmt.Errorf("flag without a name")
+		}
+		var err error
+		switch f.Type {
+		case "", "string", "strings":
+		case "bool":
+			if f.Default != "" {
+				_, err = strconv.ParseBool(f.Default)
+			}
+		case "int":
+			if f.Default != "" {
+				_, err = strconv.Atoi(f.Default)
+			}
+		case "duration":
+			if f.Default != "" {
+				_, err = time.ParseDuration(f.Default)
+			}
+		default:
+			return fmt.Errorf("flag %s has unknown type %q", f.Name, f.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("flag %s has invalid default %q: %v", f.Name, f.Default, err)
+		}
+	}
+	for _, sub := range m.Subcommands {
+		if sub.Name == "" {
+			return fmt.Errorf("subcommand without a name")
+		}
+		if err := sub.validate(); err != nil {
+			return fmt.Errorf("subcommand %s: %w", sub.Name, err)
+		}
+	}
+	return nil
+}
+
+//Register discovers the plugins and registers every one whose name isn't taken by a built in command. No plugin
+//is executed: the ones whose manifest is cached are registered from it, and the others as a command that reads
+//the manifest the first time it runs, see lazyCmd. Help and completion therefore never run a plugin.
+func Register() {
+	cache := loadCache()
+	for _, p := range Discover(Dirs()) {
+		if _, ok := CmdRegistry.Lookup(p.Name); ok || isBuiltin(p.Name) {
+			continue
+		}
+		p := p
+		if p.cached(cache) {
+			CmdRegistry.RegisterCmd(p.cmd())
+		} else {
+			CmdRegistry.RegisterCmd(p.lazy())
+		}
+	}
+}
+
+//lazy returns the command of a plugin whose manifest isn't known yet. Nothing is known of its flags until then, so
+//its arguments are taken as typed and handed over to the command built from the manifest.
+func (p *Plugin) lazy() CmdRegistry.Cmd {
+	l := &lazyPlugin{plugin: p}
+	return CmdRegistry.Cmd{
+		Name:      p.Name,
+		Usage:     "External plugin " + p.Path + ", described once it has run",
+		Category:  CmdRegistry.CategoryPlugins,
+		RawArgs:   true,
+		Mutating:  true,
+		Delegates: true,
+		New:       func(CmdRegistry.Command) CmdRegistry.Command { return &lazyCmd{lazy: l} },
+	}
+}
+
+//lazyPlugin is a plugin whose manifest is read the first time one of its invocations runs
+type lazyPlugin struct {
+	mu     sync.Mutex
+	plugin *Plugin
+	cmd    *CmdRegistry.Cmd
+}
+
+//lazyCmd is one invocation of a lazyPlugin
+type lazyCmd struct {
+	lazy *lazyPlugin
+}
+
+func (c *lazyCmd) Init(f *CmdRegistry.Flags) {}
+
+//Run reads the manifest of the plugin, caching it for the next runs of the CLI, and invokes the command built from
+//it with args
+func (c *lazyCmd) Run(ctx context.Context, args []string) error {
+	l := c.lazy
+	l.mu.Lock()
+	if l.cmd == nil {
+		cache := loadCache()
+		if err := l.plugin.load(ctx, cache); err != nil {
+			l.mu.Unlock()
+			return fmt.Errorf("plugin %s: %w", l.plugin.Name, err)
+		}
+		cache.save()
+		cmd := l.plugin.cmd()
+		l.cmd = &cmd
+	}
+	cmd := *l.cmd
+	l.mu.Unlock()
+
+	path, args := cmd.Route(args)
+	return CmdRegistry.Invoke(ctx, path, args)
+}
+
+func isBuiltin(name string) bool {
+	for _, b := range CmdRegistry.Builtins {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+//cmd builds the command tree of the plugin from its manifest
+func (p *Plugin) cmd() CmdRegistry.Cmd {
+	c := p.subcmd(p.Name, p.Manifest, nil)
+	c.Category = CmdRegistry.CategoryPlugins
+	return c
+}
+
+func (p *Plugin) subcmd(name string, m Manifest, words []string) CmdRegistry.Cmd {
+	c := CmdRegistry.Cmd{
+		Name:     name,
+		Usage:    m.Usage,
+		RawArgs:  p.Raw,
+		Examples: m.Examples,
+		Mutating: m.Mutating || p.Raw, //Nothing is known of what a plugin without a manifest does
+		New: func(parent CmdRegistry.Command) CmdRegistry.Command {
+			cmd := &pluginCmd{plugin: p, manifest: m, words: words, lists: map[string]*[]string{}}
+			if parent, ok := parent.(*pluginCmd); ok {
+				cmd.parent = parent
+			}
+			return cmd
+		},
+	}
+	for _, sub := range m.Subcommands {
+		subWords := append(append([]string{}, words...), sub.Name)
+		c.Subcmds = append(c.Subcmds, p.subcmd(sub.Name, sub.Manifest, subWords))
+	}
+	return c
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"clitool/utils"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/logging"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//pluginCmd is one invocation of a plugin command or of one of its subcommands
+type pluginCmd struct {
+	plugin   *Plugin
+	manifest Manifest
+	//words are the subcommand names from the plugin command down to this one
+	words  []string
+	parent *pluginCmd
+	specs  []*CmdRegistry.FlagSpec
+	lists  map[string]*[]string
+}
+
+//Init declares the flags listed in the manifest
+func (c *pluginCmd) Init(f *CmdRegistry.Flags) {
+	for _, mf := range c.manifest.Flags {
+		var spec *CmdRegistry.FlagSpec
+		switch mf.Type {
+		case "bool":
+			value, _ := strconv.ParseBool(mf.Default)
+			spec = f.Bool(new(bool), mf.Name, value, mf.Usage)
+		case "int":
+			value, _ := strconv.Atoi(mf.Default)
+			spec = f.Int(new(int), mf.Name, value, mf.Usage)
+		case "duration":
+			value, _ := time.ParseDuration(mf.Default)
+			spec = f.Duration(new(time.Duration), mf.Name, value, mf.Usage)
+		case "strings":
+			list := new([]string)
+			spec = f.Strings(list, mf.Name, mf.Usage)
+			c.lists[mf.Name] = list
+		default:
+			spec = f.String(new(string), mf.Name, mf.Default, mf.Usage)
+		}
+		spec.Alias(mf.Aliases...)
+		if mf.Required {
+			spec.Required()
+		}
+		if len(mf.Enum) > 0 {
+			spec.Enum(mf.Enum...)
+		}
+		if mf.Env != "" {
+			spec.Env(mf.Env)
+		}
+		if mf.Sensitive {
+			spec.Sensitive()
+		}
+		c.specs = append(c.specs, spec)
+	}
+}
+
+//Run executes the plugin with the flags of every level of the path, the subcommand names and args
+func (c *pluginCmd) Run(ctx context.Context, args []string) error {
+	argv, logged := args, args
+	if !c.plugin.Raw {
+		argv, logged = c.flagArgs(false), c.flagArgs(true)
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "-") { //Keep positional arguments from being read as flags
+				argv, logged = append(argv, "--"), append(logged, "--")
+				break
+			}
+		}
+		argv, logged = append(argv, args...), append(logged, args...)
+	}
+
+	logging.Debug(ctx, "Running plugin", "path", c.plugin.Path, "args", logged)
+	cmd := exec.Command(c.plugin.Path, argv...)
+	cmd.Stdin = output.Stdin(ctx)
+	cmd.Stdout = output.Stdout(ctx)
+	cmd.Stderr = output.Stderr(ctx)
+	cmd.Env = Environ(ctx)
+	if output.FromContext(ctx).Background {
+		shell.Detach(cmd) //Keeps Ctrl-C at the prompt from reaching the plugin of a background job
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("plugin %s: %w", c.plugin.Name, err)
+	}
+
+	done := make(chan struct{})
+	go interrupt(ctx, cmd.Process, done)
+	err := cmd.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", c.plugin.Name, err)
+	}
+	return nil
+}
+
+//interrupt stops the plugin once ctx is cancelled, first with an interrupt so it can clean up and then, if it
+//is still running after InterruptGracePeriod, by killing it
+func interrupt(ctx context.Context, process *os.Process, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(InterruptGracePeriod):
+		process.Kill()
+	}
+}
+
+//flagArgs returns the flags set on each level of the path followed by the name of the level below it,
+//e.g. --profile=dev status --watch=true. The values of sensitive flags are replaced when redact is set, for logs.
+func (c *pluginCmd) flagArgs(redact bool) []string {
+	argv := []string{}
+	if c.parent != nil {
+		argv = c.parent.flagArgs(redact)
+	}
+	if len(c.words) > 0 {
+		argv = append(argv, c.words[len(c.words)-1])
+	}
+	for _, spec := range c.specs {
+		if !spec.IsSet() {
+			continue
+		}
+		if redact && spec.IsSensitive {
+			argv = append(argv, "--"+spec.Name+"="+CmdRegistry.Redacted)
+			continue
+		}
+		if list, ok := c.lists[spec.Name]; ok {
+			for _, value := range *list {
+				argv = append(argv, "--"+spec.Name+"="+value)
+			}
+			continue
+		}
+		argv = append(argv, "--"+spec.Name+"="+spec.Value())
+	}
+	return argv
+}
+
+//Environ returns the environment of plugins and hooks: the environment of the CLI along with the credentials of the
+//current AWS session, the region and the output format
+func Environ(ctx context.Context) []string {
+	env := os.Environ()
+	if creds, err := utils.GetCredentials(ctx); err == nil {
+		env = append(env,
+			"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+			"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+			"AWS_SESSION_TOKEN="+creds.SessionToken,
+		)
+	}
+	region := utils.Region()
+	env = append(env, "AWS_REGION="+region, "AWS_DEFAULT_REGION="+region, "CLITOOL_OUTPUT="+output.FromContext(ctx).Format.String())
+	env = append(env, "CLITOOL_LOG_LEVEL="+config.Get("log.level"))
+	if exe, err := os.Executable(); err == nil {
+		env = append(env, "CLITOOL_BIN="+exe)
+	}
+	return env
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+//Session is the role last assumed with the assume command by this process, or the identity of the active
+//credentials
+type Session struct {
+	Role       string
+	Account    string
+	Expiration time.Time //Zero when the provider of the credentials doesn't tell when they expire
+}
+
+//identityTTL is how long the identity of the active credentials is reused before it is looked up again
+const identityTTL = 5 * time.Minute
+
+var (
+	sessionMu sync.Mutex
+	assumed   *Session
+
+	identity         *Session  //The identity of the active credentials, nil when the lookup failed
+	identityChecked  time.Time //When identity was last looked up, zero to look it up again
+	identityChecking bool
+	identityGen      int //Counts the changes of the active credentials, to drop the lookups of earlier ones
+)
+
+//SetSession records the role assumed from the ARN of the assumed role user,
+//e.g. arn:aws:sts::123456789012:assumed-role/admin/session, or forgets it when arn is empty
+func SetSession(arn string, expiration time.Time) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	identity, identityChecked = nil, time.Time{} //The active credentials changed
+	identityGen++
+	if arn == "" {
+		assumed = nil
+		return
+	}
+	s := parseSession(arn)
+	s.Expiration = expiration
+	assumed = &s
+}
+
+//parseSession returns the role and the account of an ARN such as arn:aws:sts::123456789012:assumed-role/admin/session
+//or arn:aws:iam::123456789012:user/me
+func parseSession(arn string) Session {
+	var s Session
+	if parts := strings.SplitN(arn, ":", 6); len(parts) == 6 {
+		s.Account = parts[4]
+		resource := strings.Split(parts[5], "/")
+		s.Role = resource[len(resource)-1]
+		if len(resource) > 1 {
+			s.Role = resource[1]
+		}
+	}
+	return s
+}
+
+//CurrentSession returns the role last assumed by this process, if any
+func CurrentSession() (Session, bool) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if assumed == nil {
+		return Session{}, false
+	}
+	return *assumed, true
+}
+
+//ActiveSession returns the role last assumed by this process or, when there is none, the identity of the active
+//credentials, like "assume whoami" shows it. The identity is looked up in the background and kept for identityTTL,
+//so the caller is never held up by STS: it isn't known until the first lookup returns, nor when the lookup fails.
+func ActiveSession() (Session, bool) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if assumed != nil {
+		return *assumed, true
+	}
+	if !identityChecking && time.Since(identityChecked) > identityTTL {
+		identityChecking = true
+		go checkIdentity(identityGen)
+	}
+	if identity == nil {
+		return Session{}, false
+	}
+	return *identity, true
+}
+
+func checkIdentity(gen int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, err := callerSession(ctx)
+
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	identityChecking = false
+	if gen != identityGen {
+		return
+	}
+	identityChecked = time.Now()
+	identity = nil
+	if err == nil {
+		identity = &s
+	}
+}
+
+//callerSession returns the identity of the default credentials, with their expiry when their provider knows it
+func callerSession(ctx context.Context) (Session, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return Session{}, AWSError(err, "creating AWS session")
+	}
+	stsSvc := sts.New(sess, &aws.Config{Region: aws.String(Region())})
+	out, err := stsSvc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return Session{}, AWSError(err, "getting caller identity")
+	}
+	s := parseSession(aws.StringValue(out.Arn))
+	if expiration, err := sess.Config.Credentials.ExpiresAt(); err == nil {
+		s.Expiration = expiration
+	}
+	return s, nil
+}
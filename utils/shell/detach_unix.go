@@ -0,0 +1,14 @@
+// +build !windows
+
+package shell
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+//Detach starts cmd in a process group of its own, so signals sent by the terminal to the foreground
+//process group don't reach it
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,11 @@
+package shell
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+//Detach starts cmd in a process group of its own, so Ctrl-C in the console doesn't reach it
+func Detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
@@ -0,0 +1,20 @@
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+//Local returns the command running line with the local shell: $SHELL, or /bin/sh if it isn't set, and cmd.exe
+//on Windows
+func Local(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	sh := os.Getenv("SHELL")
+	if sh == "" {
+		sh = "/bin/sh"
+	}
+	return exec.Command(sh, "-c", line)
+}
@@ -0,0 +1,664 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Op is the operator that chains a command to the one before it
+type Op int
+
+const (
+	//OpSeq runs the command regardless of the previous status (";" or the start of the line)
+	OpSeq Op = iota
+	//OpAnd runs the command only if the previous status was zero ("&&")
+	OpAnd
+	//OpOr runs the command only if the previous status was non zero ("||")
+	OpOr
+)
+
+//Redirect says where the output of a command goes instead of the terminal
+type Redirect int
+
+const (
+	//RedirectNone leaves the output of the command alone
+	RedirectNone Redirect = iota
+	//RedirectFile writes the output to the file named by Target, truncating it first ("> file")
+	RedirectFile
+	//RedirectAppend appends the output to the file named by Target (">> file")
+	RedirectAppend
+	//RedirectPipe feeds the output to Target, a command line run by the local shell ("| grep ...")
+	RedirectPipe
+)
+
+//Command is one command of a parsed line along with the operator chaining it to the previous command
+type Command struct {
+	//Args are the words of the command once expanded by Expand
+	Args []string
+	Op   Op
+	//Background is set on every command of a list ended by "&", which runs as a background job
+	Background bool
+	//Guarded is set on every command followed by || in its list, which handles its failure. Like in a shell, the
+	//failure of a guarded command doesn't stop a script run with set -e.
+	Guarded  bool
+	Redirect Redirect
+	//Target is the file of a file redirection once expanded, or the unparsed command line of a pipe
+	Target string
+
+	//words and target are the source of Args and of the file of a redirection, quotes and references included
+	words  []string
+	target string
+}
+
+//LookupFunc resolves the value of a variable referenced as $NAME or ${NAME}
+type LookupFunc func(name string) (string, bool)
+
+//ErrUnterminated is returned when a line ends inside quotes or right after a chaining operator
+var ErrUnterminated = errors.New("unexpected end of line")
+
+//Parse splits a line into commands the way a POSIX shell would. It handles single and double quotes,
+//backslash escapes, $VAR, ${VAR} and dotted $VAR.field references, # comments, the ;, &, && and || operators and
+//the >, >> and | redirections of the output. Everything after a | up to the end of the command is kept as is, to
+//be run by the local shell. A command has at most one redirection, and redirections of other file descriptors
+//such as 2> are rejected. A blank line or a trailing ";" gives no command, while an empty command between two
+//operators, as in "a ;; b", is a syntax error.
+//
+//References are only expanded by Expand, right before a command runs, so that it sees the variables set and the
+//status left by the commands before it on the line.
+func Parse(line string) ([]Command, error) {
+	l := &lexer{input: []rune(line)}
+	return l.parse()
+}
+
+//Expand returns the command with its words and the file of its redirection expanded through lookup, ready to
+//run. A word made only of unquoted references that are empty is dropped like in a shell, so a command may be
+//left without Args.
+func (c Command) Expand(lookup LookupFunc) (Command, error) {
+	if c.words == nil {
+		return c, nil //Already expanded
+	}
+	expanded := c
+	expanded.Args, expanded.words, expanded.target = []string{}, nil, ""
+	for _, source := range c.words {
+		l := &lexer{input: []rune(source), lookup: lookup}
+		word, ok, err := l.word()
+		if err != nil {
+			return Command{}, err
+		}
+		if ok {
+			expanded.Args = append(expanded.Args, word)
+		}
+	}
+	if c.Redirect == RedirectFile || c.Redirect == RedirectAppend {
+		l := &lexer{input: []rune(c.target), lookup: lookup}
+		target, ok, err := l.word()
+		if err != nil {
+			return Command{}, err
+		}
+		if !ok {
+			return Command{}, fmt.Errorf("ambiguous redirect %s", c.target)
+		}
+		expanded.Target = target
+	}
+	return expanded, nil
+}
+
+//Lists splits commands into their lists: commands chained with && and ||, ended by ";", "&" or the end of
+//the line. Each list starts with an OpSeq command.
+func Lists(cmds []Command) [][]Command {
+	lists := [][]Command{}
+	for i, c := range cmds {
+		if i == 0 || c.Op == OpSeq {
+			lists = append(lists, []Command{})
+		}
+		lists[len(lists)-1] = append(lists[len(lists)-1], c)
+	}
+	return lists
+}
+
+//Run executes the commands in order, honoring the exit status semantics of their chaining operators,
+//and returns the status of the last command that ran
+func Run(cmds []Command, status int, run func(c Command) int) int {
+	for _, c := range cmds {
+		if (c.Op == OpAnd && status != 0) || (c.Op == OpOr && status == 0) {
+			continue
+		}
+		status = run(c)
+	}
+	return status
+}
+
+//String returns the command as a line that Parse would read back, without its chaining operator. The references
+//of a command that wasn't expanded yet are shown as typed.
+func (c Command) String() string {
+	args, target := Quote(c.Args), Quote([]string{c.Target})
+	if c.words != nil {
+		args, target = strings.Join(c.words, " "), c.target
+	}
+	switch c.Redirect {
+	case RedirectFile:
+		return args + " > " + target
+	case RedirectAppend:
+		return args + " >> " + target
+	case RedirectPipe:
+		return args + " | " + c.Target
+	}
+	return args
+}
+
+//Quote joins args back into a line that Parse would split into the same words, quoting where needed
+func Quote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\r'\"\\$#;&|>") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+//Positional replaces the references to the arguments of a macro in text before it is parsed: $1 to $9 and ${n}
+//with the nth argument, $@ and $* with all of them and $# with their number. Values are quoted for where the
+//reference stands so that Parse reads each argument back as given, and references in single quotes are left
+//alone like any variable. Unquoted, $@ gives one word per argument while "$@" gives a single word.
+func Positional(text string, args []string) string {
+	var b strings.Builder
+	in := []rune(text)
+	quote := rune(0)
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		switch {
+		case r == '\\' && quote != '\'' && i+1 < len(in):
+			b.WriteRune(r)
+			i++
+			r = in[i]
+		case r == '\'' && quote != '"', r == '"' && quote != '\'':
+			if quote == r {
+				quote = 0
+			} else {
+				quote = r
+			}
+		case r == '$' && quote != '\'':
+			if values, n, ok := positional(in[i+1:], args); ok {
+				b.WriteString(quoteFor(values, quote))
+				i += n
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+//positional returns the arguments referenced at the start of ref, the text following a $, and the length of
+//the reference
+func positional(ref []rune, args []string) ([]string, int, bool) {
+	if len(ref) == 0 {
+		return nil, 0, false
+	}
+	n, length := 0, 1
+	switch r := ref[0]; {
+	case r == '@' || r == '*':
+		return args, 1, true
+	case r == '#':
+		return []string{strconv.Itoa(len(args))}, 1, true
+	case r >= '1' && r <= '9':
+		n = int(r - '0')
+	case r == '{':
+		end := 1
+		for end < len(ref) && ref[end] >= '0' && ref[end] <= '9' {
+			end++
+		}
+		if end == 1 || end == len(ref) || ref[end] != '}' {
+			return nil, 0, false
+		}
+		n, _ = strconv.Atoi(string(ref[1:end]))
+		length = end + 1
+	default:
+		return nil, 0, false
+	}
+	if n < 1 || n > len(args) {
+		return nil, length, true
+	}
+	return args[n-1 : n], length, true
+}
+
+//quoteFor quotes values to stand outside of quotes, where each becomes a word, or inside double quotes
+func quoteFor(values []string, quote rune) string {
+	if quote == '"' {
+		return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`).Replace(strings.Join(values, " "))
+	}
+	if len(values) == 1 && values[0] == "" {
+		return "" //An empty argument vanishes like in a shell
+	}
+	return Quote(values)
+}
+
+type lexer struct {
+	input  []rune
+	pos    int
+	lookup LookupFunc
+	//partial is set to read a line that may end in the middle of a word, see Partial
+	partial bool
+}
+
+//Partial splits a line that may end in the middle of a command, such as the text before the cursor when
+//completing, into the words of its last command. Quotes and escapes are removed, a quote left open ends with the
+//line and references are kept as typed. The last word is the one being typed, empty after a space, and typed is
+//its length as typed. ok is false when the line ends in a comment, the file of a redirection or a pipe, whose
+//words aren't arguments of the command.
+func Partial(line string) (words []string, typed int, ok bool) {
+	l := &lexer{input: []rune(line), partial: true, lookup: func(name string) (string, bool) {
+		return "$" + name, true
+	}}
+	words = []string{}
+	for {
+		l.skipSpace()
+		if l.eof() {
+			return append(words, ""), 0, true
+		}
+		switch r := l.peek(); {
+		case r == '#':
+			return nil, 0, false
+		case l.hasPrefix("&&") || l.hasPrefix("||"):
+			l.pos += 2
+			words = []string{}
+		case r == ';' || r == '&':
+			l.pos++
+			words = []string{}
+		case r == '|':
+			l.pos++
+			if _, err := l.pipeline(); err != nil || l.eof() {
+				return nil, 0, false
+			}
+		case r == '>':
+			l.pos++
+			if l.hasPrefix(">") {
+				l.pos++
+			}
+			l.skipSpace()
+			if _, _, err := l.word(); err != nil || l.eof() {
+				return nil, 0, false
+			}
+		default:
+			start := l.pos
+			word, _, err := l.word()
+			if err != nil { //An unfinished ${
+				word, l.pos = string(l.input[start:]), len(l.input)
+			}
+			words = append(words, word)
+			if l.eof() {
+				return words, l.pos - start, true
+			}
+		}
+	}
+}
+
+func (l *lexer) parse() ([]Command, error) {
+	cmds := []Command{}
+	cur := Command{Op: OpSeq}
+	pendingOp := false
+	listStart := 0 //Index in cmds of the first command of the current list
+
+	for {
+		l.skipSpace()
+		if l.eof() || l.peek() == '#' {
+			break
+		}
+
+		switch r := l.peek(); {
+		case r == ';':
+			l.pos++
+			if len(cur.words) == 0 {
+				return nil, fmt.Errorf("syntax error near \";\"")
+			}
+			cmds = append(cmds, cur)
+			cur = Command{Op: OpSeq}
+			pendingOp = false
+			listStart = len(cmds)
+		case l.hasPrefix("&&") || l.hasPrefix("||"):
+			op := string(l.input[l.pos : l.pos+2])
+			l.pos += 2
+			if len(cur.words) == 0 {
+				return nil, fmt.Errorf("syntax error near %q", op)
+			}
+			cmds = append(cmds, cur)
+			cur = Command{Op: OpAnd}
+			if op == "||" {
+				cur.Op = OpOr
+				for i := listStart; i < len(cmds); i++ {
+					cmds[i].Guarded = true
+				}
+			}
+			pendingOp = true
+		case r == '&':
+			l.pos++
+			if len(cur.words) == 0 {
+				return nil, fmt.Errorf("syntax error near \"&\"")
+			}
+			cmds = append(cmds, cur)
+			for i := listStart; i < len(cmds); i++ {
+				cmds[i].Background = true
+			}
+			cur = Command{Op: OpSeq}
+			pendingOp = false
+			listStart = len(cmds)
+		case r == '>':
+			redirect, op := RedirectFile, ">"
+			if l.hasPrefix(">>") {
+				redirect, op = RedirectAppend, ">>"
+			}
+			l.pos += len(op)
+			if len(cur.words) == 0 || cur.Redirect != RedirectNone {
+				return nil, fmt.Errorf("syntax error near %q", op)
+			}
+			l.skipSpace()
+			if l.eof() {
+				return nil, fmt.Errorf("syntax error near %q, expected a file name", op)
+			}
+			target, err := l.source()
+			if err != nil {
+				return nil, err
+			}
+			if target == "" {
+				return nil, fmt.Errorf("syntax error near %q, expected a file name", op)
+			}
+			cur.Redirect, cur.target = redirect, target
+		case r == '|':
+			l.pos++
+			if len(cur.words) == 0 || cur.Redirect != RedirectNone { //The output already goes to a file
+				return nil, fmt.Errorf("syntax error near \"|\"")
+			}
+			target, err := l.pipeline()
+			if err != nil {
+				return nil, err
+			}
+			cur.Redirect, cur.Target = RedirectPipe, target
+		default:
+			word, err := l.source()
+			if err != nil {
+				return nil, err
+			}
+			if !l.eof() && l.peek() == '>' && isNumber(word) {
+				return nil, fmt.Errorf("syntax error near %q, only the output of a command can be redirected", word+">")
+			}
+			cur.words = append(cur.words, word)
+		}
+	}
+
+	if len(cur.words) > 0 {
+		cmds = append(cmds, cur)
+	} else if pendingOp {
+		return nil, ErrUnterminated
+	}
+	return cmds, nil
+}
+
+//source reads one word and returns it as typed, to be expanded by Expand
+func (l *lexer) source() (string, error) {
+	start := l.pos
+	if _, _, err := l.word(); err != nil {
+		return "", err
+	}
+	return string(l.input[start:l.pos]), nil
+}
+
+//word reads one word. ok is false when the word was made only of unquoted expansions that were empty.
+func (l *lexer) word() (string, bool, error) {
+	var b strings.Builder
+	quoted := false
+
+	for !l.eof() {
+		r := l.peek()
+		switch {
+		case isSpace(r) || r == ';' || r == '&' || r == '|' || r == '>':
+			return b.String(), quoted || b.Len() > 0, nil
+		case r == '\\':
+			l.pos++
+			if l.eof() && l.partial {
+				break
+			}
+			if l.eof() {
+				return "", false, ErrUnterminated
+			}
+			if l.peek() != '\n' {
+				b.WriteRune(l.peek())
+			}
+			l.pos++
+		case r == '\'':
+			quoted = true
+			end := l.indexFrom('\'')
+			if end < 0 && l.partial {
+				end = len(l.input)
+			}
+			if end < 0 {
+				return "", false, ErrUnterminated
+			}
+			b.WriteString(string(l.input[l.pos+1 : end]))
+			l.pos = end + 1
+			if l.pos > len(l.input) {
+				l.pos = len(l.input)
+			}
+		case r == '"':
+			quoted = true
+			l.pos++
+			if err := l.doubleQuoted(&b); err != nil {
+				return "", false, err
+			}
+		case r == '$':
+			if err := l.expand(&b); err != nil {
+				return "", false, err
+			}
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+	return b.String(), quoted || b.Len() > 0, nil
+}
+
+//pipeline reads the command line following a |, up to the ;, &, && or || ending the command or a # comment.
+//It is returned unparsed, quotes included, since the local shell parses it.
+func (l *lexer) pipeline() (string, error) {
+	start := l.pos
+loop:
+	for !l.eof() {
+		switch r := l.peek(); {
+		case r == ';' || r == '&' || l.hasPrefix("||"):
+			break loop
+		case r == '#' && isSpace(l.input[l.pos-1]):
+			break loop
+		case r == '\\':
+			l.pos += 2
+		case r == '\'':
+			end := l.indexFrom('\'')
+			if end < 0 {
+				return "", ErrUnterminated
+			}
+			l.pos = end + 1
+		case r == '"':
+			for l.pos++; !l.eof() && l.peek() != '"'; l.pos++ {
+				if l.peek() == '\\' {
+					l.pos++
+				}
+			}
+			if l.eof() {
+				return "", ErrUnterminated
+			}
+			l.pos++
+		default:
+			l.pos++
+		}
+	}
+	if l.pos > len(l.input) {
+		return "", ErrUnterminated //A backslash ended the line
+	}
+	line := strings.TrimSpace(string(l.input[start:l.pos]))
+	if line == "" {
+		return "", ErrUnterminated
+	}
+	return line, nil
+}
+
+//doubleQuoted reads the rest of a double quoted string, in which only \, " and $ are special
+func (l *lexer) doubleQuoted(b *strings.Builder) error {
+	for !l.eof() {
+		r := l.peek()
+		switch r {
+		case '"':
+			l.pos++
+			return nil
+		case '\\':
+			l.pos++
+			if l.eof() && l.partial {
+				return nil
+			}
+			if l.eof() {
+				return ErrUnterminated
+			}
+			next := l.peek()
+			switch next {
+			case '"', '\\', '$':
+				b.WriteRune(next)
+			case '\n':
+			default:
+				b.WriteRune('\\')
+				b.WriteRune(next)
+			}
+			l.pos++
+		case '$':
+			if err := l.expand(b); err != nil {
+				return err
+			}
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+	if l.partial {
+		return nil
+	}
+	return ErrUnterminated
+}
+
+//expand reads a variable reference starting at $ and writes its value. A $ that doesn't start a
+//reference is kept as is.
+func (l *lexer) expand(b *strings.Builder) error {
+	l.pos++
+	if l.eof() {
+		b.WriteRune('$')
+		return nil
+	}
+
+	var name string
+	switch r := l.peek(); {
+	case r == '{':
+		end := l.indexFrom('}')
+		if end < 0 {
+			return ErrUnterminated
+		}
+		name = string(l.input[l.pos+1 : end])
+		if name == "" {
+			return fmt.Errorf("bad substitution")
+		}
+		l.pos = end + 1
+	case r == '?':
+		name = "?"
+		l.pos++
+	case isNameStart(r):
+		start := l.pos
+		for !l.eof() && isNameRune(l.peek()) {
+			l.pos++
+		}
+		name = string(l.input[start:l.pos])
+		//A dotted reference such as $last.id is used when lookup knows it, otherwise the name ends at the
+		//first dot like in a POSIX shell
+		ends := []int{}
+		for end := l.pos; end+1 < len(l.input) && l.input[end] == '.' && isNameRune(l.input[end+1]); {
+			for end++; end < len(l.input) && isNameRune(l.input[end]); end++ {
+			}
+			ends = append(ends, end)
+		}
+		for i := len(ends) - 1; i >= 0 && l.lookup != nil; i-- {
+			if value, ok := l.lookup(string(l.input[start:ends[i]])); ok {
+				b.WriteString(value)
+				l.pos = ends[i]
+				return nil
+			}
+		}
+	default:
+		b.WriteRune('$')
+		return nil
+	}
+
+	if l.lookup != nil {
+		if value, ok := l.lookup(name); ok {
+			b.WriteString(value)
+		}
+	}
+	return nil
+}
+
+func (l *lexer) skipSpace() {
+	for !l.eof() && isSpace(l.peek()) {
+		l.pos++
+	}
+}
+
+func (l *lexer) eof() bool {
+	return l.pos >= len(l.input)
+}
+
+func (l *lexer) peek() rune {
+	return l.input[l.pos]
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	return strings.HasPrefix(string(l.input[l.pos:]), s)
+}
+
+//indexFrom returns the index of the next r after the current position, or -1
+func (l *lexer) indexFrom(r rune) int {
+	for i := l.pos + 1; i < len(l.input); i++ {
+		if l.input[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+//IsName reports whether s can be the name of a variable referenced as $NAME
+func IsName(s string) bool {
+	for i, r := range s {
+		if !isNameRune(r) || (i == 0 && !isNameStart(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+//isNumber reports whether s is made only of digits, like the file descriptor of a redirection such as 2>
+func isNumber(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+func isNameRune(r rune) bool {
+	return isNameStart(r) || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,228 @@
+package shell
+
+import (
+	"reflect"
+	"testing"
+)
+
+//vars stands in for the variables of a session
+var vars = map[string]string{
+	"HOME":    "/home/me",
+	"EMPTY":   "",
+	"last.id": "i-0a",
+	"?":       "1",
+}
+
+func lookup(name string) (string, bool) {
+	value, ok := vars[name]
+	return value, ok
+}
+
+//parse parses line and expands every command of it
+func parse(line string) ([]Command, error) {
+	cmds, err := Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range cmds {
+		if cmds[i], err = c.Expand(lookup); err != nil {
+			return nil, err
+		}
+	}
+	return cmds, nil
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`echo hello   world`, []string{"echo", "hello", "world"}},
+		{`echo 'a b' "c d"`, []string{"echo", "a b", "c d"}},
+		{`echo 'it''s' it\'s`, []string{"echo", "its", "it's"}},
+		{`echo "say \"hi\"" "a\b"`, []string{"echo", `say "hi"`, `a\b`}},
+		{`echo a\ b a\\b`, []string{"echo", "a b", `a\b`}},
+		{`echo '$HOME' \$HOME "\$HOME"`, []string{"echo", "$HOME", "$HOME", "$HOME"}},
+		{`echo $HOME ${HOME}/bin "$HOME/x" pre$HOME`, []string{"echo", "/home/me", "/home/me/bin", "/home/me/x", "pre/home/me"}},
+		{`echo $last.id $last.id.name $HOME.bak`, []string{"echo", "i-0a", "i-0a.name", "/home/me.bak"}},
+		{`echo $UNSET $EMPTY x`, []string{"echo", "x"}},
+		{`echo "$UNSET" '' x`, []string{"echo", "", "", "x"}},
+		{`echo $? $ a$ $1x`, []string{"echo", "1", "$", "a$", "$1x"}},
+		{`echo a # a comment`, []string{"echo", "a"}},
+		{`echo a#b '# b' "#c"`, []string{"echo", "a#b", "# b", "#c"}},
+	}
+	for _, test := range tests {
+		cmds, err := parse(test.line)
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+		if len(cmds) != 1 {
+			t.Errorf("%s: got %d commands, want 1", test.line, len(cmds))
+			continue
+		}
+		if !reflect.DeepEqual(cmds[0].Args, test.want) {
+			t.Errorf("%s: got %q, want %q", test.line, cmds[0].Args, test.want)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		line string
+		want []Command
+	}{
+		{"", []Command{}},
+		{"  # only a comment", []Command{}},
+		{"a;", []Command{{Args: []string{"a"}}}},
+		{"a; b && c || d", []Command{
+			{Args: []string{"a"}},
+			{Args: []string{"b"}, Guarded: true},
+			{Args: []string{"c"}, Op: OpAnd, Guarded: true},
+			{Args: []string{"d"}, Op: OpOr},
+		}},
+		{"a && b & c", []Command{
+			{Args: []string{"a"}, Background: true},
+			{Args: []string{"b"}, Op: OpAnd, Background: true},
+			{Args: []string{"c"}},
+		}},
+		{"a>out.txt", []Command{{Args: []string{"a"}, Redirect: RedirectFile, Target: "out.txt"}}},
+		{`a >> "$HOME/a log" b`, []Command{{Args: []string{"a", "b"}, Redirect: RedirectAppend, Target: "/home/me/a log"}}},
+		{"a | grep -v 'x;y' $HOME; b", []Command{
+			{Args: []string{"a"}, Redirect: RedirectPipe, Target: "grep -v 'x;y' $HOME"},
+			{Args: []string{"b"}},
+		}},
+		{"a | sort # sorted", []Command{{Args: []string{"a"}, Redirect: RedirectPipe, Target: "sort"}}},
+		{"a | wc -l || b", []Command{
+			{Args: []string{"a"}, Redirect: RedirectPipe, Target: "wc -l", Guarded: true},
+			{Args: []string{"b"}, Op: OpOr},
+		}},
+	}
+	for _, test := range tests {
+		cmds, err := parse(test.line)
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmds, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.line, cmds, test.want)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	lines := []string{
+		"a ;; b",
+		"; a",
+		"a & ; b",
+		"a && ; b",
+		"&& a",
+		"a || || b",
+		"a & &",
+		"a &&",
+		"a ||",
+		"a |",
+		"a | ;",
+		"a >",
+		"> f",
+		"a > ; b",
+		"a > f | grep x",
+		"a > f > g",
+		"a > f >> g",
+		"a 2>err",
+		"a 2>>err",
+		"a 1> out",
+		"echo 'open",
+		`echo "open`,
+		`echo a\`,
+		"echo ${HOME",
+		"echo ${}",
+		"a | grep 'open",
+	}
+	for _, line := range lines {
+		if cmds, err := parse(line); err == nil {
+			t.Errorf("%s: got %+v, want a syntax error", line, cmds)
+		}
+	}
+	if _, err := Parse("a &&"); err != ErrUnterminated {
+		t.Errorf("a &&: got %v, want %v", err, ErrUnterminated)
+	}
+}
+
+func TestExpandRedirect(t *testing.T) {
+	cmds, err := Parse("a > $UNSET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmds[0].Expand(lookup); err == nil {
+		t.Errorf("a > $UNSET: got no error, want an ambiguous redirect")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	args := []string{"plain", "", "a b", "it's", `"q"`, `back\slash`, "$HOME", "#x", "a;b", "a&b", "a|b", "a>b", "tab\there"}
+	cmds, err := parse(Quote(args))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 1 || !reflect.DeepEqual(cmds[0].Args, args) {
+		t.Errorf("got %+v, want the words %q", cmds, args)
+	}
+}
+
+func TestPartial(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []string
+		typed int
+		ok    bool
+	}{
+		{"", []string{""}, 0, true},
+		{"kss", []string{"kss"}, 3, true},
+		{"kssh -e pr", []string{"kssh", "-e", "pr"}, 2, true},
+		{"kssh -t ", []string{"kssh", "-t", ""}, 0, true},
+		{"set X=1 && kssh -a 'My Ap", []string{"kssh", "-a", "My Ap"}, 6, true},
+		{`kssh -a "My \"Ap`, []string{"kssh", "-a", `My "Ap`}, 8, true},
+		{`kssh -a My\ Ap`, []string{"kssh", "-a", "My Ap"}, 6, true},
+		{`echo a\`, []string{"echo", "a"}, 2, true},
+		{"echo $HO", []string{"echo", "$HO"}, 3, true},
+		{"echo ${HO", []string{"echo", "${HO"}, 4, true},
+		{"a; b & c", []string{"c"}, 1, true},
+		{"a | grep x; b ", []string{"b", ""}, 0, true},
+		{"echo a # co", nil, 0, false},
+		{"echo a > fi", nil, 0, false},
+		{"echo a | gr", nil, 0, false},
+	}
+	for _, test := range tests {
+		words, typed, ok := Partial(test.line)
+		if !reflect.DeepEqual(words, test.words) || typed != test.typed || ok != test.ok {
+			t.Errorf("%q: got %q, %d, %v, want %q, %d, %v", test.line, words, typed, ok, test.words, test.typed, test.ok)
+		}
+	}
+}
+
+func TestPositional(t *testing.T) {
+	tests := []struct {
+		text string
+		args []string
+		want string
+	}{
+		{"echo $1 $2", []string{"a", "b c"}, "echo a 'b c'"},
+		{"echo ${2}x", []string{"a", "b"}, "echo bx"},
+		{"echo $@", []string{"a", "b c"}, "echo a 'b c'"},
+		{"echo $*", []string{"a", "b"}, "echo a b"},
+		{`echo "$@"`, []string{"a", "b c"}, `echo "a b c"`},
+		{`echo "$1"`, []string{`a"$b`}, `echo "a\"\$b"`},
+		{"echo $#", []string{"a", "b"}, "echo 2"},
+		{"echo $3 x", []string{"a"}, "echo  x"},
+		{"echo $1", []string{""}, "echo "},
+		{"echo '$1' \\$1", []string{"a"}, "echo '$1' \\$1"},
+		{"echo $HOME $1 ${x}", []string{"a"}, "echo $HOME a ${x}"},
+		{"echo $1", []string{"it's"}, `echo 'it'\''s'`},
+	}
+	for _, test := range tests {
+		if got := Positional(test.text, test.args); got != test.want {
+			t.Errorf("Positional(%q, %q) = %q, want %q", test.text, test.args, got, test.want)
+		}
+	}
+}
@@ -1,49 +1,177 @@
 package utils
 
 import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-func createSession() *session.Session {
-	sess, err := session.NewSession()
-	if err != nil {
-		fmt.Println("Error creating session", err)
+//DefaultRegion is the AWS region used when none is configured
+const DefaultRegion = "us-east-1"
+
+func init() {
+	config.Register(config.Key{
+		Name:           "region",
+		Usage:          "AWS region of the EC2 and STS calls",
+		Default:        DefaultRegion,
+		Env:            []string{"CLITOOL_REGION", "AWS_REGION", "AWS_DEFAULT_REGION"},
+		ProjectAllowed: true,
+	})
+}
+
+//Region returns the configured AWS region
+func Region() string {
+	return config.Get("region")
+}
+
+//authCodes are the AWS error codes caused by missing, expired or insufficient credentials
+var authCodes = map[string]bool{
+	"AccessDenied":          true,
+	"AccessDeniedException": true,
+	"AuthFailure":           true,
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+	"InvalidClientTokenId":  true,
+	"NoCredentialProviders": true,
+	"SignatureDoesNotMatch": true,
+	"UnauthorizedOperation": true,
+}
+
+//notFoundCodes are the AWS error codes of resources that don't exist
+var notFoundCodes = map[string]bool{
+	"NoSuchEntity":               true,
+	"InvalidInstanceID.NotFound": true,
+	"ResourceNotFoundException":  true,
+}
+
+//AWSError classifies an error of the AWS SDK into the error types of CmdRegistry, so that e.g. expired
+//credentials exit with CmdRegistry.ExitAuth. action describes what failed, e.g. "assuming role".
+func AWSError(err error, action string) error {
+	if err == nil {
+		return nil
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return CmdRegistry.Remotef("error %s: %w", action, err)
+	}
+	switch code := aerr.Code(); {
+	case code == request.CanceledErrorCode:
+		return fmt.Errorf("error %s: %w", action, context.Canceled)
+	case authCodes[code]:
+		return CmdRegistry.Authf("error %s: %s, check your credentials or assume a role first", action, aerr.Message())
+	case notFoundCodes[code]:
+		return CmdRegistry.NotFoundf("error %s: %s", action, aerr.Message())
 	}
-	return sess
+	return CmdRegistry.Remotef("error %s: %w", action, err)
+}
+
+func createSession() (*session.Session, error) {
+	sess, err := session.NewSession()
+	return sess, AWSError(err, "creating AWS session")
 }
 
 //GetInstances will take a set of filters and execute the Describe Instances command and return the results
-func GetInstances(filters []*ec2.Filter) (*ec2.DescribeInstancesOutput, error) {
-	sess := createSession()
-	ec2Svc := ec2.New(sess, &aws.Config{Region: aws.String("us-east-1")}) //TODO(Get region from AWS config)
+func GetInstances(ctx context.Context, filters []*ec2.Filter) (*ec2.DescribeInstancesOutput, error) {
+	sess, err := createSession()
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.New(sess, &aws.Config{Region: aws.String(Region())})
 	describeParams := &ec2.DescribeInstancesInput{Filters: filters}
-	return ec2Svc.DescribeInstances(describeParams)
+	out, err := ec2Svc.DescribeInstancesWithContext(ctx, describeParams)
+	return out, AWSError(err, "describing instances")
+}
+
+//GetTagValues returns the distinct values of the given tag key across all EC2 instances, sorted
+func GetTagValues(ctx context.Context, key string) ([]string, error) {
+	sess, err := createSession()
+	if err != nil {
+		return nil, err
+	}
+	ec2Svc := ec2.New(sess, &aws.Config{Region: aws.String(Region())})
+	tagsInput := &ec2.DescribeTagsInput{Filters: []*ec2.Filter{
+		{Name: aws.String("key"), Values: []*string{aws.String(key)}},
+		{Name: aws.String("resource-type"), Values: []*string{aws.String("instance")}},
+	}}
+
+	seen := map[string]bool{}
+	err = ec2Svc.DescribeTagsPagesWithContext(ctx, tagsInput, func(page *ec2.DescribeTagsOutput, lastPage bool) bool {
+		for _, tag := range page.Tags {
+			seen[aws.StringValue(tag.Value)] = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, AWSError(err, "describing tags")
+	}
+
+	values := make([]string, 0, len(seen))
+	for value := range seen {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, nil
 }
 
 //AssumeRole executes the assume command using the specified profile, RoleArn, and KeyId
-func AssumeRole(roleArn string, profile string, keyID string) (*sts.AssumeRoleOutput, error) {
+func AssumeRole(ctx context.Context, roleArn string, profile string, keyID string) (*sts.AssumeRoleOutput, error) {
 	sess, err := session.NewSessionWithOptions(session.Options{
 		Profile: profile,
 	})
 	if err != nil {
-		fmt.Println("Error creating session!", err)
+		return nil, AWSError(err, "creating AWS session")
 	}
 
-	stsSvc := sts.New(sess)
+	stsSvc := sts.New(sess, &aws.Config{Region: aws.String(Region())})
 	roleSessionName := createSessionName(keyID) //We use the AwsAccessKeyId to create the session name to leave an audit trail
 	assumeInput := sts.AssumeRoleInput{
 		RoleArn:         &roleArn,
 		RoleSessionName: &roleSessionName,
 	}
 
-	return stsSvc.AssumeRole(&assumeInput)
+	out, err := stsSvc.AssumeRoleWithContext(ctx, &assumeInput)
+	if err == nil && out.Credentials == nil {
+		err = errors.New("the response holds no credentials")
+	}
+	return out, AWSError(err, "assuming role")
+}
+
+//GetCallerIdentity returns the identity of the credentials of the given profile, or of the default credentials if profile is empty
+func GetCallerIdentity(ctx context.Context, profile string) (*sts.GetCallerIdentityOutput, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Profile: profile,
+	})
+	if err != nil {
+		return nil, AWSError(err, "creating AWS session")
+	}
+
+	stsSvc := sts.New(sess, &aws.Config{Region: aws.String(Region())})
+	out, err := stsSvc.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	return out, AWSError(err, "getting caller identity")
+}
+
+//GetCredentials returns the credentials the default AWS credential chain resolves to, i.e. the role last
+//assumed with the assume command unless the environment overrides it
+func GetCredentials(ctx context.Context) (credentials.Value, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return credentials.Value{}, AWSError(err, "creating AWS session")
+	}
+	creds, err := sess.Config.Credentials.GetWithContext(ctx)
+	return creds, AWSError(err, "getting credentials")
 }
 
 func createSessionName(keyID string) string {
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/output"
+	"clitool/utils/shell"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	varsMu sync.Mutex
+	//sessionVars are the variables set with "set NAME=value" for the rest of the session or script
+	sessionVars = map[string]string{}
+	//lastResult is the last result emitted by a command run in the foreground, see lookupVar
+	lastResult interface{}
+	//lastStatus is the exit status of the last command run at the prompt or by a script, $?
+	lastStatus int
+)
+
+//lookupVar resolves variables referenced in interactive input and scripts. $? is the exit status of the last
+//command, $_ the first field of the last result, e.g. the instance ID after "kssh list", and $last.<field> a
+//field of it, e.g. $last.private_ip or $last.2.id for the third row. Anything else is a session variable or
+//comes from the environment.
+func lookupVar(name string) (string, bool) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	switch {
+	case name == "?":
+		return strconv.Itoa(lastStatus), true
+	case name == "_":
+		if lastResult == nil {
+			return "", false
+		}
+		return output.Field(lastResult, "")
+	case strings.HasPrefix(name, "last."):
+		//A field the result doesn't have expands to nothing rather than leaving the rest of the path behind
+		if lastResult == nil {
+			return "", true
+		}
+		value, _ := output.Field(lastResult, strings.TrimPrefix(name, "last."))
+		return value, true
+	}
+	if value, ok := sessionVars[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+//exitStatus returns the exit status of the last command, $?
+func exitStatus() int {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	return lastStatus
+}
+
+func setExitStatus(status int) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	lastStatus = status
+}
+
+//recordResults makes the results a foreground command emits the last result, forgetting the previous one.
+//The results of the commands run by hooks are left out, $_ refers to the command they run around.
+func recordResults(ctx context.Context, streams *output.Streams) {
+	if streams.Background || ctx.Value(hookingKey{}) != nil {
+		streams.Emitted = nil //A hook inherits the streams of the command it runs around
+		return
+	}
+	varsMu.Lock()
+	lastResult = nil
+	varsMu.Unlock()
+	streams.Emitted = func(v interface{}) {
+		varsMu.Lock()
+		lastResult = v
+		varsMu.Unlock()
+	}
+}
+
+//setVar sets a session variable from a NAME=value argument of set
+func setVar(arg string) error {
+	i := strings.Index(arg, "=")
+	name := arg[:i]
+	if !shell.IsName(name) || name == "_" || name == "last" {
+		return CmdRegistry.Usagef("invalid variable name %q", name)
+	}
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	sessionVars[name] = arg[i+1:]
+	return nil
+}
+
+//processUnset removes session variables
+func processUnset(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		return fail(ctx, CmdRegistry.Usagef("unset expects the names of the variables to remove"))
+	}
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	for _, name := range args {
+		delete(sessionVars, name)
+	}
+	return CmdRegistry.ExitOK
+}
+
+//processVars prints the session variables as NAME=value lines that set would read back
+func processVars(ctx context.Context, args []string) int {
+	if len(args) > 0 {
+		return fail(ctx, CmdRegistry.Usagef("vars takes no arguments"))
+	}
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	names := make([]string, 0, len(sessionVars))
+	for name := range sessionVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(output.Stdout(ctx), name+"="+shell.Quote([]string{sessionVars[name]}))
+	}
+	return CmdRegistry.ExitOK
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"clitool/utils/CmdRegistry"
+	"clitool/utils/config"
+	"clitool/utils/output"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+//rowsCmd emits the rows it was created with, standing in for a command such as "kssh list"
+type rowsCmd struct {
+	rows []instanceRow
+}
+
+type instanceRow struct {
+	ID        string `json:"id"`
+	PrivateIP string `json:"private_ip"`
+}
+
+func (r *rowsCmd) Init(f *CmdRegistry.Flags) {}
+
+func (r *rowsCmd) Run(ctx context.Context, args []string) error {
+	return output.Emit(ctx, r.rows)
+}
+
+func init() {
+	rows := []instanceRow{{"i-0a", "10.0.0.1"}, {"i-0b", "10.0.0.2"}}
+	CmdRegistry.RegisterCmd(CmdRegistry.Cmd{
+		Name:   "test-rows",
+		Hidden: true,
+		New:    func(CmdRegistry.Command) CmdRegistry.Command { return &rowsCmd{rows: rows} },
+	})
+}
+
+//newSession keeps the settings of the machine and the variables and results of other tests out of a test. It
+//returns the context to run lines in and the buffer their output goes to.
+func newSession(t *testing.T) (context.Context, *bytes.Buffer) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("CLITOOL_CONFIG", filepath.Join(dir, "config.json"))
+	config.Reload()
+	t.Cleanup(config.Reload)
+
+	varsMu.Lock()
+	sessionVars, lastResult, lastStatus = map[string]string{}, nil, 0
+	varsMu.Unlock()
+
+	var out bytes.Buffer
+	return output.WithStreams(context.Background(), output.Streams{Out: &out, Err: &out}), &out
+}
+
+//runLines runs lines like the prompt would and fails the test if one of them fails
+func runLines(t *testing.T, ctx context.Context, out *bytes.Buffer, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if status := processLine(ctx, line); status != CmdRegistry.ExitOK {
+			t.Fatalf("%q exited with status %d: %s", line, status, out.String())
+		}
+	}
+}
+
+//checkVars checks the value of variables as a command would see them
+func checkVars(t *testing.T, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		if got, _ := lookupVar(name); got != value {
+			t.Errorf("$%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+//TestResultOnSameLine checks that a command refers to the result and the status of the command before it on the
+//same line, which are only known once that command ran
+func TestResultOnSameLine(t *testing.T) {
+	ctx, out := newSession(t)
+	runLines(t, ctx, out,
+		"set ID=before",
+		"test-rows && set ID=$_ IP=$last.1.private_ip",
+		"test-missing; set STATUS=$?",
+	)
+	checkVars(t, map[string]string{"ID": "i-0a", "IP": "10.0.0.2", "STATUS": "4"})
+}
+
+//TestLastFields checks how $last.<field> resolves against the rows of the last result
+func TestLastFields(t *testing.T) {
+	ctx, out := newSession(t)
+	for _, name := range []string{"last.id", "_"} {
+		if value, _ := lookupVar(name); value != "" {
+			t.Errorf("$%s = %q before any result, want nothing", name, value)
+		}
+	}
+
+	runLines(t, ctx, out, "test-rows")
+	checkVars(t, map[string]string{
+		"_":               "i-0a",
+		"last.id":         "i-0a", //A field of a list is the one of its first row
+		"last.private_ip": "10.0.0.1",
+		"last.0.id":       "i-0a",
+		"last.1.id":       "i-0b",
+		"last.2.id":       "", //Past the last row
+		"last.1.missing":  "",
+		"last.1":          `{"id":"i-0b","private_ip":"10.0.0.2"}`,
+	})
+	if _, ok := lookupVar("last.missing"); !ok {
+		t.Errorf("$last.missing isn't defined, want it to expand to nothing")
+	}
+
+	//The whole dotted path is the field, braces end it
+	runLines(t, ctx, out, `set A=$last.1.id.suffix B=${last.1.id}.suffix C="x$last.missing"`)
+	checkVars(t, map[string]string{"A": "", "B": "i-0b.suffix", "C": "x"})
+}
+
+//TestUnset checks that unset removes session variables, uncovering the environment variables they shadowed
+func TestUnset(t *testing.T) {
+	ctx, out := newSession(t)
+	t.Setenv("CLITOOL_TEST_SHADOWED", "from the environment")
+	runLines(t, ctx, out,
+		"set CLITOOL_TEST_SHADOWED=session A=1 B=2",
+		"unset A CLITOOL_TEST_SHADOWED NEVER_SET",
+	)
+	checkVars(t, map[string]string{"A": "", "B": "2", "CLITOOL_TEST_SHADOWED": "from the environment"})
+	if _, ok := lookupVar("A"); ok {
+		t.Errorf("$A is still set after unset")
+	}
+
+	out.Reset()
+	runLines(t, ctx, out, "vars")
+	if got, want := out.String(), "B=2\n"; got != want {
+		t.Errorf("vars printed %q, want %q", got, want)
+	}
+	if status := processLine(ctx, "unset"); status != CmdRegistry.ExitUsage {
+		t.Errorf("unset without names exited with status %d, want %d", status, CmdRegistry.ExitUsage)
+	}
+}